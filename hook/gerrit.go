@@ -5,51 +5,212 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
 	"github.com/bytebase/relay/service"
+	"github.com/bytebase/relay/util"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
 	flag "github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
 )
 
 var (
-	_                   Hooker = (*gerritHooker)(nil)
-	gerritProject       string
-	gerritProjectBranch string
-	gerritURL           string
-	gerritAccount       string
-	gerritPassword      string
+	_                      GerritHooker = (*gerritHooker)(nil)
+	gerritProject          string
+	gerritProjectBranch    string
+	gerritURL              string
+	gerritAccount          string
+	gerritPassword         string
+	gerritToken            string
+	gerritCookie           string
+	gerritTimeout          time.Duration
+	gerritSQLReview        bool
+	gerritRoutes           string
+	gerritFetchConcurrency int
+	gerritFileCacheSize    int
+	gerritMaxFileSize      int64
 )
 
+// GerritHooker is the Gerrit hooker, the sinks find the routes of the messages and post the
+// reviews back to the changes through it.
+type GerritHooker interface {
+	Hooker
+	// Route returns the route by name, or nil if not found.
+	Route(name string) *route.Route
+	// PostReview posts a review to a revision of a Gerrit change.
+	PostReview(ctx context.Context, changeID, revisionID string, review *payload.GerritReviewInput) error
+}
+
 // NewGerrit creates a Gerrit hooker
-func NewGerrit() Hooker {
+func NewGerrit() GerritHooker {
+	var opts []service.GerritOption
+	if gerritTimeout > 0 {
+		opts = append(opts, service.WithGerritTimeout(gerritTimeout))
+	}
+	if gerritToken != "" {
+		opts = append(opts, service.WithGerritBearerToken(gerritToken))
+	}
+	if gerritCookie != "" {
+		opts = append(opts, service.WithGerritCookie(gerritCookie))
+	}
+	gerritService := service.NewGerrit(gerritURL, gerritAccount, gerritPassword, opts...)
+	gerritService.EnableFileCache(gerritFileCacheSize)
+	gerritService.SetMaxFileSize(gerritMaxFileSize)
 	return &gerritHooker{
-		gerritService: service.NewGerrit(gerritURL, gerritAccount, gerritPassword),
+		gerritService: gerritService,
 	}
 }
 
 func init() {
-	// For demo we only supports monitor one branch in one project.
+	// --gerrit-repository and --gerrit-branch are the single route used if --gerrit-routes is not set.
 	flag.StringVar(&gerritProject, "gerrit-repository", "", "The Gerrit repository name")
 	flag.StringVar(&gerritProjectBranch, "gerrit-branch", "main", "The branch name in Gerrit repository")
+	flag.StringVar(&gerritRoutes, "gerrit-routes", "", "The path of the JSON file listing the Gerrit project and branch patterns to watch, overrides --gerrit-repository and --gerrit-branch")
 
 	flag.StringVar(&gerritURL, "gerrit-url", "https://gerrit.bytebase.com", "The Gerrit service URL")
 	flag.StringVar(&gerritAccount, "gerrit-account", "", "The Gerrit service account name")
 	flag.StringVar(&gerritPassword, "gerrit-password", "", "The Gerrit service account password")
+	flag.StringVar(&gerritToken, "gerrit-token", "", "The bearer token to authenticate the Gerrit service account instead of --gerrit-password, e.g. with the OAuth plugin")
+	flag.StringVar(&gerritCookie, "gerrit-cookie", "", "The cookie sent with the Gerrit requests, e.g. the o=... cookie from .gitcookies")
+	flag.DurationVar(&gerritTimeout, "gerrit-timeout", 30*time.Second, "The timeout of every Gerrit REST request")
+	flag.BoolVar(&gerritSQLReview, "gerrit-sql-review", false, "Check the SQL files in new patch sets and post the findings back to the change")
+	flag.IntVar(&gerritFetchConcurrency, "gerrit-fetch-concurrency", 8, "The max number of concurrent file content fetches from Gerrit")
+	flag.Int64Var(&gerritMaxFileSize, "gerrit-max-file-size", 1<<20, "The max size in bytes of a SQL file, the larger files are rejected, 0 for no limit")
+	flag.IntVar(&gerritFileCacheSize, "gerrit-file-cache-size", 256, "The max number of files whose content is cached, 0 to disable the cache")
 }
 
 type gerritHooker struct {
 	gerritService *service.GerritService
+	routes        []*route.Route
+	submissions   gerritSubmissionCache
+	// sshConfig is the config to consume the stream-events, nil if --gerrit-ssh-address is not set.
+	sshConfig *ssh.ClientConfig
+}
+
+// gerritSubmissionTTL is how long a change stays claimed by its submission, longer than the
+// webhook retry period.
+const gerritSubmissionTTL = 24 * time.Hour
+
+// gerritSubmissionCache tracks the changes relayed as a part of a submission, so that the
+// change-merged events of the other changes in the same submission are skipped.
+type gerritSubmissionCache struct {
+	mu      sync.Mutex
+	claimed map[int]*gerritSubmissionClaim
+}
+
+type gerritSubmissionClaim struct {
+	// by is the number of the merged change whose event relays the submission.
+	by int
+	at time.Time
+}
+
+// claim claims the change list for the merged change, returns false if the merged change
+// has been claimed by the event of another change in the same submission. The redelivered
+// event of the claiming change is allowed to claim again.
+func (c *gerritSubmissionCache) claim(merged int, changeList []*service.GerritChangeInfo) bool {
+	if merged == 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for number, claim := range c.claimed {
+		if now.Sub(claim.at) > gerritSubmissionTTL {
+			delete(c.claimed, number)
+		}
+	}
+	if claim, ok := c.claimed[merged]; ok && claim.by != merged {
+		return false
+	}
+	if c.claimed == nil {
+		c.claimed = map[int]*gerritSubmissionClaim{}
+	}
+	for _, change := range changeList {
+		c.claimed[change.Number] = &gerritSubmissionClaim{by: merged, at: now}
+	}
+	return true
+}
+
+func (c *gerritSubmissionCache) release(changeList []*service.GerritChangeInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, change := range changeList {
+		delete(c.claimed, change.Number)
+	}
+}
+
+// GerritRoutes returns the routes loaded from --gerrit-routes, or the single route of --gerrit-repository
+// and --gerrit-branch if not set.
+func GerritRoutes() ([]*route.Route, error) {
+	if gerritRoutes != "" {
+		return route.Load(gerritRoutes)
+	}
+	r, err := route.New("default", gerritProject, gerritProjectBranch)
+	if err != nil {
+		return nil, err
+	}
+	return []*route.Route{r}, nil
+}
+
+func (hooker *gerritHooker) Route(name string) *route.Route {
+	for _, r := range hooker.routes {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (hooker *gerritHooker) PostReview(ctx context.Context, changeID, revisionID string, review *payload.GerritReviewInput) error {
+	return hooker.gerritService.PostReview(ctx, changeID, revisionID, review)
 }
 
 func (hooker *gerritHooker) handler() (func(r *http.Request) Response, error) {
+	routes, err := GerritRoutes()
+	if err != nil {
+		return nil, err
+	}
+	hooker.routes = routes
+	if gerritSSHAddress != "" {
+		config, err := gerritSSHConfig()
+		if err != nil {
+			return nil, err
+		}
+		hooker.sshConfig = config
+	}
+
 	return func(r *http.Request) Response {
-		if gerritURL == "" {
+		var message payload.GerritEvent
+		err := json.NewDecoder(r.Body).Decode(&message)
+		if err != nil {
 			return Response{
-				httpCode: http.StatusAccepted,
-				detail:   "Skip, --gerrit-url is not set",
+				httpCode: http.StatusBadRequest,
+				detail:   fmt.Sprintf("Failed to decode request body: %q", err),
 			}
 		}
+
+		return hooker.process(r.Context(), &message)
+	}, nil
+}
+
+// process processes the Gerrit event received from either the webhook or the stream-events.
+func (hooker *gerritHooker) process(ctx context.Context, message *payload.GerritEvent) Response {
+	if gerritURL == "" {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   "Skip, --gerrit-url is not set",
+		}
+	}
+	// The basic auth requires both the account and the password, the token and the cookie are used alone.
+	if gerritToken == "" && gerritCookie == "" {
 		if gerritAccount == "" {
 			return Response{
 				httpCode: http.StatusAccepted,
@@ -59,69 +220,332 @@ func (hooker *gerritHooker) handler() (func(r *http.Request) Response, error) {
 		if gerritPassword == "" {
 			return Response{
 				httpCode: http.StatusAccepted,
-				detail:   "Skip, --gerrit-password is not set",
+				detail:   "Skip, none of --gerrit-password, --gerrit-token and --gerrit-cookie is set",
 			}
 		}
+	}
 
-		var message payload.GerritEvent
-		err := json.NewDecoder(r.Body).Decode(&message)
-		if err != nil {
+	switch message.Type {
+	case payload.GerritEventRefUpdated:
+		return hooker.processRefUpdated(ctx, message.RefUpdate)
+	case payload.GerritEventCommentAdded:
+		return hooker.processCommentAdded(ctx, message)
+	case payload.GerritEventChangeMerged:
+	case payload.GerritEventPatchSetCreated:
+		if !gerritSQLReview {
 			return Response{
-				httpCode: http.StatusBadRequest,
-				detail:   fmt.Sprintf("Failed to decode request body: %q", err),
+				httpCode: http.StatusAccepted,
+				detail:   fmt.Sprintf("Skip %s event, --gerrit-sql-review is not set", message.Type),
 			}
 		}
+	default:
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip %s event", message.Type),
+		}
+	}
 
-		if message.Type != payload.GerritEventChangeMerged {
-			return Response{
-				httpCode: http.StatusAccepted,
-				detail:   fmt.Sprintf("Skip %s event", message.Type),
-			}
+	if message.Change == nil || message.PatchSet == nil {
+		return Response{
+			httpCode: http.StatusBadRequest,
+			detail:   fmt.Sprintf("Invalid %s event, the change or the patch set is missing", message.Type),
+		}
+	}
+
+	r := route.Find(hooker.routes, message.Change.Project, message.Change.Branch)
+	if r == nil {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip the message for %s branch in %s project", message.Change.Branch, message.Change.Project),
 		}
+	}
 
-		if message.Change.Project != gerritProject || message.Change.Branch != gerritProjectBranch {
+	if message.Type == payload.GerritEventPatchSetCreated {
+		changedFileList, err := hooker.listChangedSQLFiles(ctx, r, message.Change.ID, message.PatchSet.Revision)
+		if err != nil {
+			return hooker.failure(ctx, err, message.Change.ID, message.PatchSet.Revision)
+		}
+		if len(changedFileList) == 0 {
 			return Response{
 				httpCode: http.StatusAccepted,
-				detail:   fmt.Sprintf("Skip the message for %s branch in %s project", message.Change.Branch, message.Change.Project),
+				detail:   "Skip, no SQL file is changed in the patch set",
 			}
 		}
+		return Response{
+			httpCode: http.StatusOK,
+			payload: payload.GerritPatchSetCheckMessage{
+				Route:    r.Name,
+				ChangeID: message.Change.ID,
+				Revision: message.PatchSet.Revision,
+				Files:    changedFileList,
+			},
+		}
+	}
+
+	changeList, err := hooker.submittedTogether(ctx, r, message)
+	if err != nil {
+		return Response{
+			httpCode: http.StatusInternalServerError,
+			detail:   err.Error(),
+		}
+	}
+	if !hooker.submissions.claim(message.Change.Number, changeList) {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip, change %d has been relayed together with the changes submitted with it", message.Change.Number),
+		}
+	}
 
-		ctx := context.Background()
-		fileMap, err := hooker.gerritService.ListFilesInChange(ctx, message.Change.ID, message.PatchSet.Revision)
+	changedFileList := []*payload.GerritChangedFile{}
+	var numberList []int
+	for _, change := range changeList {
+		fileList, err := hooker.listChangedSQLFiles(ctx, r, change.ID, change.CurrentRevision)
 		if err != nil {
-			return Response{
-				httpCode: http.StatusInternalServerError,
-				payload:  err.Error(),
+			// Let the redelivered events retry the submission, unless the files are rejected since
+			// retrying does not help.
+			if !isRejectedFiles(err) {
+				hooker.submissions.release(changeList)
 			}
+			return hooker.failure(ctx, err, change.ID, change.CurrentRevision)
+		}
+		for _, file := range fileList {
+			file.Change = change.Number
+		}
+		changedFileList = append(changedFileList, fileList...)
+		numberList = append(numberList, change.Number)
+	}
+
+	return Response{
+		httpCode: http.StatusOK,
+		payload: payload.GerritFileChangeMessage{
+			Route:   r.Name,
+			Changes: numberList,
+			Files:   changedFileList,
+		},
+	}
+}
+
+// submittedTogether returns the merged change and the changes submitted together with it in the
+// submission order, only the changes routed by the same route are included.
+func (hooker *gerritHooker) submittedTogether(ctx context.Context, r *route.Route, message *payload.GerritEvent) ([]*service.GerritChangeInfo, error) {
+	merged := &service.GerritChangeInfo{
+		ID:              message.Change.ID,
+		Project:         message.Change.Project,
+		Branch:          message.Change.Branch,
+		Number:          message.Change.Number,
+		CurrentRevision: message.PatchSet.Revision,
+	}
+	// The change number is missing in the events from the old Gerrit versions.
+	if merged.Number == 0 {
+		return []*service.GerritChangeInfo{merged}, nil
+	}
+
+	changes, err := hooker.gerritService.ListSubmittedTogether(ctx, strconv.Itoa(merged.Number))
+	if err != nil {
+		return nil, err
+	}
+	var changeList []*service.GerritChangeInfo
+	// Gerrit lists the changes in reverse topological order, so the ancestors are submitted first.
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if change.Number == merged.Number {
+			changeList = append(changeList, merged)
+			continue
+		}
+		if route.Find(hooker.routes, change.Project, change.Branch) != r {
+			continue
 		}
+		change.ID = strconv.Itoa(change.Number)
+		changeList = append(changeList, change)
+	}
+	if len(changeList) == 0 {
+		// The merged change itself is missing if it is the only change of the submission.
+		changeList = append(changeList, merged)
+	}
+	return changeList, nil
+}
+
+// listChangedSQLFiles returns the SQL files selected by the route with their content in the revision of the change.
+func (hooker *gerritHooker) listChangedSQLFiles(ctx context.Context, r *route.Route, changeID, revision string) ([]*payload.GerritChangedFile, error) {
+	fileMap, err := hooker.gerritService.ListFilesInChange(ctx, changeID, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectSQLFiles(r, fileMap, func(fileName string) (string, error) {
+		return hooker.gerritService.GetFileContent(ctx, changeID, revision, fileName)
+	})
+}
 
-		changedFileList := []*payload.GerritChangedFile{}
-		for fileName := range fileMap {
-			if strings.HasPrefix(fileName, "/") {
-				continue
+// selectSQLFiles selects the files matching the route from the file map and fetches their content,
+// the files are sorted by name. The files are selected before any fetch.
+func selectSQLFiles(r *route.Route, fileMap map[string]*service.GerritFileInfo, getContent func(fileName string) (string, error)) ([]*payload.GerritChangedFile, error) {
+	changedFileList := []*payload.GerritChangedFile{}
+	rejected := &rejectedFilesError{}
+	for fileName, info := range fileMap {
+		if strings.HasPrefix(fileName, "/") {
+			// The magic files, e.g. /COMMIT_MSG and /MERGE_LIST.
+			continue
+		}
+		if !r.MatchFile(fileName) {
+			continue
+		}
+		if info.Status == payload.GerritFileDeleted {
+			// The migration has been applied, deleting its file does not revert it.
+			continue
+		}
+		if info.Binary {
+			fmt.Printf("Skip the binary file %q\n", fileName)
+			continue
+		}
+		changedFileList = append(changedFileList, &payload.GerritChangedFile{
+			FileName: fileName,
+			Status:   info.Status,
+			OldPath:  info.OldPath,
+		})
+		if gerritMaxFileSize > 0 && info.Size > gerritMaxFileSize {
+			rejected.add(fileName, fmt.Sprintf("%d bytes exceeds the max file size %d bytes", info.Size, gerritMaxFileSize))
+		}
+	}
+	sort.Slice(changedFileList, func(i, j int) bool {
+		return changedFileList[i].FileName < changedFileList[j].FileName
+	})
+	// Reject the files known to be too large before fetching any content.
+	if len(rejected.files) > 0 {
+		return nil, rejected
+	}
+
+	if err := fetchContent(changedFileList, getContent); err != nil {
+		return nil, err
+	}
+	return changedFileList, nil
+}
+
+// fetchContent fetches the content of the files with at most --gerrit-fetch-concurrency concurrent fetches,
+// and decodes it to UTF-8. The renamed files are skipped, since they are reported by the sink without being
+// applied. All fetches are attempted, and the error lists every file failed to fetch, or if all fetches
+// succeed, every file rejected for its size or encoding.
+func fetchContent(changedFileList []*payload.GerritChangedFile, getContent func(fileName string) (string, error)) error {
+	concurrency := gerritFetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var result error
+	fetched, failed := 0, 0
+	rejected := &rejectedFilesError{}
+	for _, file := range changedFileList {
+		if file.Status == payload.GerritFileRenamed {
+			continue
+		}
+		fetched++
+		file := file
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			content, err := getContent(file.FileName)
+			if errors.Is(err, service.ErrFileTooLarge) {
+				mu.Lock()
+				rejected.add(file.FileName, fmt.Sprintf("exceeds the max file size %d bytes", gerritMaxFileSize))
+				mu.Unlock()
+				return
 			}
-			if !strings.HasSuffix(fileName, ".sql") {
-				continue
+			if err != nil {
+				mu.Lock()
+				failed++
+				result = multierror.Append(result, fmt.Errorf("failed to fetch %q: %w", file.FileName, err))
+				mu.Unlock()
+				return
+			}
+			// The file size is missing in the file list of the old Gerrit versions.
+			if gerritMaxFileSize > 0 && int64(len(content)) > gerritMaxFileSize {
+				mu.Lock()
+				rejected.add(file.FileName, fmt.Sprintf("%d bytes exceeds the max file size %d bytes", len(content), gerritMaxFileSize))
+				mu.Unlock()
+				return
 			}
-			content, err := hooker.gerritService.GetFileContent(ctx, message.Change.ID, message.PatchSet.Revision, fileName)
+			text, err := util.DecodeText([]byte(content))
 			if err != nil {
-				return Response{
-					httpCode: http.StatusInternalServerError,
-					payload:  err.Error(),
-				}
+				mu.Lock()
+				rejected.add(file.FileName, err.Error())
+				mu.Unlock()
+				return
 			}
+			file.Content = text
+		}()
+	}
+	wg.Wait()
+	if result != nil {
+		return fmt.Errorf("failed to fetch %d of %d SQL files: %w", failed, fetched, result)
+	}
+	if len(rejected.files) > 0 {
+		return rejected
+	}
+	return nil
+}
 
-			changedFileList = append(changedFileList, &payload.GerritChangedFile{
-				FileName: fileName,
-				Content:  content,
-			})
-		}
+// rejectedFilesError is the error of the files rejected for their size or encoding. Unlike the fetch
+// failures, retrying does not help, so the rejection is reported to the originator.
+type rejectedFilesError struct {
+	files   []string
+	reasons map[string]string
+}
 
+func (e *rejectedFilesError) add(fileName, reason string) {
+	if e.reasons == nil {
+		e.reasons = map[string]string{}
+	}
+	e.files = append(e.files, fileName)
+	e.reasons[fileName] = reason
+}
+
+func (e *rejectedFilesError) Error() string {
+	sort.Strings(e.files)
+	var list []string
+	for _, fileName := range e.files {
+		list = append(list, fmt.Sprintf("%q: %s", fileName, e.reasons[fileName]))
+	}
+	return fmt.Sprintf("rejected %d SQL files: %s", len(e.files), strings.Join(list, "; "))
+}
+
+// message returns the rejection reported on the change.
+func (e *rejectedFilesError) message() string {
+	sort.Strings(e.files)
+	lines := []string{"Relay rejected the SQL files, fix them in a new patch set or change:"}
+	for _, fileName := range e.files {
+		lines = append(lines, fmt.Sprintf("* %s: %s", fileName, e.reasons[fileName]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isRejectedFiles(err error) bool {
+	var rejected *rejectedFilesError
+	return errors.As(err, &rejected)
+}
+
+// failure returns the response to the error. The rejected files are reported on the revision of the change
+// if the change is set, and respond with 422 instead of 500 since retrying does not help.
+func (hooker *gerritHooker) failure(ctx context.Context, err error, changeID, revision string) Response {
+	var rejected *rejectedFilesError
+	if !errors.As(err, &rejected) {
 		return Response{
-			httpCode: http.StatusOK,
-			payload: payload.GerritFileChangeMessage{
-				Files: changedFileList,
-			},
+			httpCode: http.StatusInternalServerError,
+			detail:   err.Error(),
 		}
-	}, nil
+	}
+	if changeID != "" {
+		if err := hooker.gerritService.PostReview(ctx, changeID, revision, &payload.GerritReviewInput{Message: rejected.message()}); err != nil {
+			fmt.Printf("Failed to report the rejected files on change %s: %v\n", changeID, err)
+		}
+	}
+	return Response{
+		httpCode: http.StatusUnprocessableEntity,
+		detail:   rejected.Error(),
+	}
 }
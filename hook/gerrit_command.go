@@ -0,0 +1,121 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	gerritCommandUsers []string
+)
+
+// gerritCommandPrefix is the prefix of the command line in a comment, e.g. "/relay retry".
+const gerritCommandPrefix = "/relay "
+
+func init() {
+	flag.StringSliceVar(&gerritCommandUsers, "gerrit-command-users", nil, "A comma separated list of the Gerrit user names allowed to run /relay commands by commenting on changes, the commands are disabled if empty")
+}
+
+// processCommentAdded runs the /relay command in the comment on a routed change.
+func (hooker *gerritHooker) processCommentAdded(ctx context.Context, message *payload.GerritEvent) Response {
+	if message.Change == nil || message.PatchSet == nil {
+		return Response{
+			httpCode: http.StatusBadRequest,
+			detail:   fmt.Sprintf("Invalid %s event, the change or the patch set is missing", message.Type),
+		}
+	}
+	command, ok := parseGerritCommand(message.Comment)
+	if !ok {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   "Skip, no /relay command in the comment",
+		}
+	}
+	if len(gerritCommandUsers) == 0 {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   "Skip, --gerrit-command-users is not set",
+		}
+	}
+	if message.Author == nil || !isGerritCommandUser(message.Author.Username) {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   "Skip, the comment author is not allowed to run /relay commands",
+		}
+	}
+
+	r := route.Find(hooker.routes, message.Change.Project, message.Change.Branch)
+	if r == nil {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip the message for %s branch in %s project", message.Change.Branch, message.Change.Project),
+		}
+	}
+
+	switch command {
+	case payload.GerritCommandDryRun:
+	case payload.GerritCommandRetry, payload.GerritCommandApply:
+		if message.Change.Status != payload.GerritChangeMerged {
+			return hooker.replyCommand(ctx, message, fmt.Sprintf("Relay %s is only allowed on merged changes, try /relay dry-run instead.", command))
+		}
+	default:
+		return hooker.replyCommand(ctx, message, fmt.Sprintf("Unknown command %q, supported commands are /relay retry, /relay dry-run and /relay apply.", command))
+	}
+
+	changedFileList, err := hooker.listChangedSQLFiles(ctx, r, message.Change.ID, message.PatchSet.Revision)
+	if err != nil {
+		return hooker.failure(ctx, err, message.Change.ID, message.PatchSet.Revision)
+	}
+
+	return Response{
+		httpCode: http.StatusOK,
+		payload: payload.GerritCommandMessage{
+			Route:    r.Name,
+			Command:  command,
+			ChangeID: message.Change.ID,
+			Revision: message.PatchSet.Revision,
+			Files:    changedFileList,
+		},
+	}
+}
+
+// replyCommand replies the text on the change without running the command.
+func (hooker *gerritHooker) replyCommand(ctx context.Context, message *payload.GerritEvent, text string) Response {
+	if err := hooker.gerritService.PostReview(ctx, message.Change.ID, message.PatchSet.Revision, &payload.GerritReviewInput{Message: text}); err != nil {
+		return Response{
+			httpCode: http.StatusInternalServerError,
+			detail:   err.Error(),
+		}
+	}
+	return Response{
+		httpCode: http.StatusAccepted,
+		detail:   text,
+	}
+}
+
+// parseGerritCommand returns the command in the first line starting with "/relay " in the comment.
+// Gerrit prefixes the comment with a line like "Patch Set 3:".
+func parseGerritCommand(comment string) (payload.GerritCommand, bool) {
+	for _, line := range strings.Split(comment, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, gerritCommandPrefix) {
+			return payload.GerritCommand(strings.TrimSpace(strings.TrimPrefix(line, gerritCommandPrefix))), true
+		}
+	}
+	return "", false
+}
+
+func isGerritCommandUser(username string) bool {
+	for _, user := range gerritCommandUsers {
+		if user == username {
+			return true
+		}
+	}
+	return false
+}
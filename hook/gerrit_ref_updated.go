@@ -0,0 +1,176 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
+	"github.com/bytebase/relay/service"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	gerritDirectPush bool
+)
+
+// gerritMaxDirectPushCommits is the max number of commits walked from the new revision to the old one.
+const gerritMaxDirectPushCommits = 100
+
+func init() {
+	flag.BoolVar(&gerritDirectPush, "gerrit-direct-push", false, "Relay the SQL files in the commits pushed directly to the branch bypassing review, from the ref-updated events")
+}
+
+// processRefUpdated relays the SQL files in the commits pushed directly to a routed branch.
+// The commits merged through review are skipped since they are relayed by the change-merged events.
+func (hooker *gerritHooker) processRefUpdated(ctx context.Context, update *payload.GerritRefUpdate) Response {
+	if !gerritDirectPush {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip %s event, --gerrit-direct-push is not set", payload.GerritEventRefUpdated),
+		}
+	}
+	if update == nil {
+		return Response{
+			httpCode: http.StatusBadRequest,
+			detail:   fmt.Sprintf("Missing refUpdate in %s event", payload.GerritEventRefUpdated),
+		}
+	}
+
+	branch := strings.TrimPrefix(update.RefName, "refs/heads/")
+	if strings.HasPrefix(branch, "refs/") {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip the update of %s", update.RefName),
+		}
+	}
+	if isZeroRevision(update.OldRev) || isZeroRevision(update.NewRev) {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip the creation or deletion of %s branch", branch),
+		}
+	}
+	r := route.Find(hooker.routes, update.Project, branch)
+	if r == nil {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip the message for %s branch in %s project", branch, update.Project),
+		}
+	}
+
+	commitList, err := hooker.directPushedCommits(ctx, update)
+	if err != nil {
+		return Response{
+			httpCode: http.StatusInternalServerError,
+			detail:   err.Error(),
+		}
+	}
+	if len(commitList) == 0 {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   "Skip, all commits are merged through review",
+		}
+	}
+
+	fileMap, err := hooker.listFilesInCommits(ctx, update.Project, commitList)
+	if err != nil {
+		return Response{
+			httpCode: http.StatusInternalServerError,
+			detail:   err.Error(),
+		}
+	}
+	changedFileList, err := selectSQLFiles(r, fileMap, func(fileName string) (string, error) {
+		return hooker.gerritService.GetFileContentInCommit(ctx, update.Project, update.NewRev, fileName)
+	})
+	if err != nil {
+		// There is no change to report the rejected files on.
+		return hooker.failure(ctx, err, "", "")
+	}
+	if len(changedFileList) == 0 {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   "Skip, no SQL file is changed by the direct push",
+		}
+	}
+
+	return Response{
+		httpCode: http.StatusOK,
+		payload: payload.GerritFileChangeMessage{
+			Route: r.Name,
+			Files: changedFileList,
+		},
+	}
+}
+
+// directPushedCommits walks the first parent chain from the new revision to the old one, and returns
+// the commits not merged through review in chronological order. The merge commits are skipped, since
+// the merge commits created by Gerrit on submission contain the files of the merged changes.
+func (hooker *gerritHooker) directPushedCommits(ctx context.Context, update *payload.GerritRefUpdate) ([]string, error) {
+	commits, err := hooker.gerritService.ListCommits(ctx, update.Project, update.OldRev, update.NewRev, gerritMaxDirectPushCommits)
+	if err != nil {
+		return nil, err
+	}
+
+	var commitList []string
+	for _, commit := range commits {
+		if len(commit.Parents) != 1 {
+			continue
+		}
+		changes, err := hooker.gerritService.QueryChanges(ctx, fmt.Sprintf("commit:%s project:%s", commit.Commit, update.Project))
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) == 0 {
+			commitList = append(commitList, commit.Commit)
+		}
+	}
+	return commitList, nil
+}
+
+// listFilesInCommits lists the files changed by the commits in chronological order, and squashes the
+// file status, e.g. a file added and then modified is added, and a file added and then deleted is omitted.
+// A renamed file carries the status of its old path forward, e.g. a file added and then renamed is added
+// at the new path.
+func (hooker *gerritHooker) listFilesInCommits(ctx context.Context, project string, commitList []string) (map[string]*service.GerritFileInfo, error) {
+	result := map[string]*service.GerritFileInfo{}
+	for _, commit := range commitList {
+		fileMap, err := hooker.gerritService.ListFilesInCommit(ctx, project, commit)
+		if err != nil {
+			return nil, err
+		}
+		for fileName, info := range fileMap {
+			if info.Status == payload.GerritFileRenamed {
+				if prev, ok := result[info.OldPath]; ok {
+					delete(result, info.OldPath)
+					switch prev.Status {
+					case payload.GerritFileAdded:
+						info.Status = payload.GerritFileAdded
+						info.OldPath = ""
+					case payload.GerritFileRenamed:
+						info.OldPath = prev.OldPath
+					case payload.GerritFileModified, payload.GerritFileRewritten:
+						// The modification of the applied migration is still reported at the new path.
+						info.Status = prev.Status
+					}
+				}
+			}
+			prev, ok := result[fileName]
+			switch {
+			case ok && prev.Status == payload.GerritFileAdded && info.Status == payload.GerritFileDeleted:
+				delete(result, fileName)
+			case ok && prev.Status == payload.GerritFileAdded:
+				prev.Binary = info.Binary
+				prev.Size = info.Size
+			default:
+				result[fileName] = info
+			}
+		}
+	}
+	return result, nil
+}
+
+func isZeroRevision(revision string) bool {
+	return strings.Trim(revision, "0") == ""
+}
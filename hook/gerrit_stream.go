@@ -0,0 +1,179 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/service"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	_                    streamer = (*gerritHooker)(nil)
+	gerritSSHAddress     string
+	gerritSSHUser        string
+	gerritSSHKeyFile     string
+	gerritSSHKnownHosts  string
+	gerritEventsLog      bool
+	gerritEventsLogSince time.Duration
+)
+
+func init() {
+	flag.StringVar(&gerritSSHAddress, "gerrit-ssh-address", "", "The host:port of the Gerrit SSH daemon, consume the events from stream-events instead of the webhook if set, e.g. gerrit.example.com:29418")
+	flag.StringVar(&gerritSSHUser, "gerrit-ssh-user", "", "The Gerrit SSH user name, default to --gerrit-account")
+	flag.StringVar(&gerritSSHKeyFile, "gerrit-ssh-key-file", "", "The path of the private key to authenticate the Gerrit SSH user")
+	flag.StringVar(&gerritSSHKnownHosts, "gerrit-ssh-known-hosts", "", "The path of the known_hosts file to verify the Gerrit SSH host key")
+	flag.BoolVar(&gerritEventsLog, "gerrit-events-log", false, "Catch up with the events missed while stream-events is disconnected, requires the events-log plugin")
+	flag.DurationVar(&gerritEventsLogSince, "gerrit-events-log-since", 0, "How far back to catch up with the events-log plugin on startup, 0 to only catch up after reconnections")
+}
+
+func (hooker *gerritHooker) stream(ctx context.Context, dispatch func(ctx context.Context, resp Response) (int, string)) {
+	if hooker.sshConfig == nil {
+		return
+	}
+
+	c := &gerritEventCursor{}
+	if gerritEventsLogSince > 0 {
+		c.last = time.Now().Add(-gerritEventsLogSince).Unix()
+	}
+	// handle handles the live event, or the event replayed by the events-log plugin.
+	handle := func(ctx context.Context, line []byte, replayed bool) {
+		var message payload.GerritEvent
+		if err := json.Unmarshal(line, &message); err != nil {
+			fmt.Printf("Failed to decode Gerrit event %q: %v\n", line, err)
+			return
+		}
+		if !c.advance(&message, replayed) {
+			return
+		}
+		// Only the event types handled by process are relevant, e.g. skip the frequent ref-replicated events.
+		switch {
+		case message.Change != nil && message.PatchSet != nil:
+		case message.Type == payload.GerritEventRefUpdated && message.RefUpdate != nil:
+		default:
+			return
+		}
+		code, detail := dispatch(ctx, hooker.process(ctx, &message))
+		fmt.Printf("Gerrit %s event: %d %s\n", message.Type, code, detail)
+	}
+	connected := func(ctx context.Context) {
+		since := c.since()
+		if !gerritEventsLog || since == 0 {
+			return
+		}
+		lines, err := hooker.gerritService.ListEventsSince(ctx, time.Unix(since, 0))
+		if err != nil {
+			fmt.Printf("Failed to catch up with Gerrit events since %s: %v\n", time.Unix(since, 0), err)
+			return
+		}
+		for _, line := range lines {
+			handle(ctx, line, true)
+		}
+	}
+
+	fmt.Printf("Consuming Gerrit stream-events from %s\n", gerritSSHAddress)
+	service.NewGerritStream(gerritSSHAddress, hooker.sshConfig).Run(ctx, connected, func(ctx context.Context, line []byte) {
+		handle(ctx, line, false)
+	})
+}
+
+func gerritSSHConfig() (*ssh.ClientConfig, error) {
+	user := gerritSSHUser
+	if user == "" {
+		user = gerritAccount
+	}
+	key, err := os.ReadFile(gerritSSHKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --gerrit-ssh-key-file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse --gerrit-ssh-key-file: %w", err)
+	}
+	if gerritSSHKnownHosts == "" {
+		return nil, fmt.Errorf("--gerrit-ssh-known-hosts is required to verify the Gerrit host key")
+	}
+	hostKeyCallback, err := knownhosts.New(gerritSSHKnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --gerrit-ssh-known-hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// gerritEventCursorWindow is how long the handled events are remembered before the latest one,
+// longer than the events delivered out of order.
+const gerritEventCursorWindow = 10 * time.Minute
+
+// gerritEventCursor tracks the events handled, so that the events replayed by the events-log
+// plugin after a reconnection are handled exactly once.
+type gerritEventCursor struct {
+	mu sync.Mutex
+	// last is the creation time of the latest handled event.
+	last int64
+	// seen maps the handled events to their creation time, the events created more than
+	// gerritEventCursorWindow before last are forgotten.
+	seen map[string]int64
+}
+
+// advance reports whether the event should be handled and records it. The live events are always
+// handled, even if created before the latest handled event. The replayed events are handled
+// unless they have been handled.
+func (c *gerritEventCursor) advance(message *payload.GerritEvent, replayed bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := gerritEventKey(message)
+	if _, ok := c.seen[key]; ok && replayed {
+		return false
+	}
+	if c.seen == nil {
+		c.seen = map[string]int64{}
+	}
+	c.seen[key] = message.EventCreatedOn
+	if message.EventCreatedOn > c.last {
+		c.last = message.EventCreatedOn
+		for k, createdOn := range c.seen {
+			if createdOn < c.last-int64(gerritEventCursorWindow/time.Second) {
+				delete(c.seen, k)
+			}
+		}
+	}
+	return true
+}
+
+func (c *gerritEventCursor) since() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// gerritEventKey identifies the event, the creation time only has the second precision.
+func gerritEventKey(message *payload.GerritEvent) string {
+	key := fmt.Sprintf("%s/%d", message.Type, message.EventCreatedOn)
+	if message.Change != nil {
+		key += "/" + message.Change.ID
+	}
+	if message.PatchSet != nil {
+		key += "/" + message.PatchSet.Revision
+	}
+	if message.RefUpdate != nil {
+		key += "/" + message.RefUpdate.RefName + "/" + message.RefUpdate.NewRev
+	}
+	if message.Author != nil {
+		key += "/" + message.Author.Username + "/" + message.Comment
+	}
+	return key
+}
@@ -0,0 +1,354 @@
+package hook
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/service"
+)
+
+func TestGerritHandlerMalformedEvent(t *testing.T) {
+	account, password, sqlReview := gerritAccount, gerritPassword, gerritSQLReview
+	t.Cleanup(func() {
+		gerritAccount, gerritPassword, gerritSQLReview = account, password, sqlReview
+	})
+	gerritAccount, gerritPassword, gerritSQLReview = "relay", "secret", true
+
+	type test struct {
+		name string
+		body string
+	}
+
+	tests := []test{
+		{name: "change-merged without change", body: `{"type":"change-merged","patchSet":{"revision":"abc"}}`},
+		{name: "change-merged without patch set", body: `{"type":"change-merged","change":{"id":"I1"}}`},
+		{name: "patchset-created without change", body: `{"type":"patchset-created","patchSet":{"revision":"abc"}}`},
+		{name: "patchset-created without patch set", body: `{"type":"patchset-created","change":{"id":"I1"}}`},
+		{name: "comment-added without change", body: `{"type":"comment-added","patchSet":{"revision":"abc"},"comment":"/relay retry"}`},
+		{name: "comment-added without patch set", body: `{"type":"comment-added","change":{"id":"I1"},"comment":"/relay retry"}`},
+	}
+
+	handler, err := NewGerrit().handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/gerrit", strings.NewReader(tc.body))
+			if resp := handler(r); resp.httpCode != http.StatusBadRequest {
+				t.Errorf("Expect %d, got %d %q", http.StatusBadRequest, resp.httpCode, resp.detail)
+			}
+		})
+	}
+}
+
+func TestGerritEventCursor(t *testing.T) {
+	merged := func(id string, createdOn int64) *payload.GerritEvent {
+		return &payload.GerritEvent{
+			Type:           payload.GerritEventChangeMerged,
+			Change:         &payload.GerritChange{ID: id},
+			PatchSet:       &payload.GerritPatchSet{Revision: "rev-" + id},
+			EventCreatedOn: createdOn,
+		}
+	}
+
+	type step struct {
+		name     string
+		event    *payload.GerritEvent
+		replayed bool
+		want     bool
+	}
+	steps := []step{
+		{name: "live", event: merged("a", 100), want: true},
+		{name: "live at the same second", event: merged("b", 100), want: true},
+		{name: "live created before the cursor", event: merged("c", 90), want: true},
+		{name: "replayed handled", event: merged("a", 100), replayed: true, want: false},
+		{name: "replayed handled before the cursor", event: merged("c", 90), replayed: true, want: false},
+		{name: "replayed missed", event: merged("d", 110), replayed: true, want: true},
+		{name: "replayed twice", event: merged("d", 110), replayed: true, want: false},
+		{name: "live after the window", event: merged("e", 100+int64(gerritEventCursorWindow.Seconds())+1), want: true},
+		// The events older than the window are forgotten, the events-log replays since the cursor only.
+		{name: "replayed forgotten", event: merged("a", 100), replayed: true, want: true},
+	}
+
+	c := &gerritEventCursor{}
+	for _, s := range steps {
+		if got := c.advance(s.event, s.replayed); got != s.want {
+			t.Errorf("%s: expect advance %v, got %v", s.name, s.want, got)
+		}
+	}
+	if want := 100 + int64(gerritEventCursorWindow.Seconds()) + 1; c.since() != want {
+		t.Errorf("Expect the cursor at %d, got %d", want, c.since())
+	}
+}
+
+func TestGerritEventKey(t *testing.T) {
+	comment := func(username, text string) *payload.GerritEvent {
+		return &payload.GerritEvent{
+			Type:           payload.GerritEventCommentAdded,
+			Change:         &payload.GerritChange{ID: "a"},
+			PatchSet:       &payload.GerritPatchSet{Revision: "rev"},
+			Author:         &payload.GerritAccount{Username: username},
+			Comment:        text,
+			EventCreatedOn: 100,
+		}
+	}
+	if gerritEventKey(comment("alice", "/relay retry")) == gerritEventKey(comment("bob", "/relay retry")) {
+		t.Error("Expect different keys for the comments by different authors")
+	}
+	if gerritEventKey(comment("alice", "/relay retry")) == gerritEventKey(comment("alice", "/relay apply")) {
+		t.Error("Expect different keys for different comments")
+	}
+}
+
+func TestGerritHandlerInvalidSSHConfig(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	address, key := gerritSSHAddress, gerritSSHKeyFile
+	t.Cleanup(func() {
+		gerritSSHAddress, gerritSSHKeyFile = address, key
+	})
+	gerritSSHAddress, gerritSSHKeyFile = "gerrit.example.com:29418", keyFile
+
+	if _, err := (&gerritHooker{}).handler(); err == nil {
+		t.Fatal("Expect error for the invalid SSH key")
+	}
+}
+
+func TestListFilesInCommits(t *testing.T) {
+	commits := map[string]string{
+		"c1": `{"db/prod/orders##001##ddl##init.sql": {"status": "A"}, "db/prod/users##001##ddl##init.sql": {}, "db/prod/items##001##ddl##init.sql": {"status": "A"}}`,
+		"c2": `{"db/prod/orders##002##ddl##init.sql": {"status": "R", "old_path": "db/prod/orders##001##ddl##init.sql", "size": 42}, "db/prod/users##002##ddl##init.sql": {"status": "R", "old_path": "db/prod/users##001##ddl##init.sql"}, "db/prod/legacy##002##ddl##init.sql": {"status": "R", "old_path": "db/prod/legacy##001##ddl##init.sql"}}`,
+		"c3": `{"db/prod/orders##003##ddl##init.sql": {"status": "R", "old_path": "db/prod/orders##002##ddl##init.sql"}, "db/prod/legacy##003##ddl##init.sql": {"status": "R", "old_path": "db/prod/legacy##002##ddl##init.sql"}, "db/prod/items##001##ddl##init.sql": {"status": "D"}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for commit, files := range commits {
+			if r.URL.Path == "/a/projects/db/commits/"+commit+"/files/" {
+				_, _ = w.Write([]byte(")]}'\n" + files))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	hooker := &gerritHooker{gerritService: service.NewGerrit(server.URL, "", "")}
+	fileMap, err := hooker.listFilesInCommits(context.Background(), "db", []string{"c1", "c2", "c3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fileName    string
+		wantStatus  payload.GerritFileStatus
+		wantOldPath string
+	}{
+		// Added and then renamed twice is added at the last path.
+		{fileName: "db/prod/orders##003##ddl##init.sql", wantStatus: payload.GerritFileAdded},
+		// Modified and then renamed is still modified.
+		{fileName: "db/prod/users##002##ddl##init.sql", wantStatus: payload.GerritFileModified, wantOldPath: "db/prod/users##001##ddl##init.sql"},
+		// Renamed twice is renamed from the original path.
+		{fileName: "db/prod/legacy##003##ddl##init.sql", wantStatus: payload.GerritFileRenamed, wantOldPath: "db/prod/legacy##001##ddl##init.sql"},
+	}
+	if len(fileMap) != len(tests) {
+		t.Errorf("Expect %d files, got %v", len(tests), fileMap)
+	}
+	for _, tc := range tests {
+		info, ok := fileMap[tc.fileName]
+		if !ok {
+			t.Errorf("Expect %s in the files", tc.fileName)
+			continue
+		}
+		if info.Status != tc.wantStatus || info.OldPath != tc.wantOldPath {
+			t.Errorf("Expect %s with status %s from %q, got %s from %q", tc.fileName, tc.wantStatus, tc.wantOldPath, info.Status, info.OldPath)
+		}
+	}
+}
+
+func TestGerritSubmissionCache(t *testing.T) {
+	submission := []*service.GerritChangeInfo{{Number: 1}, {Number: 2}, {Number: 3}}
+
+	type step struct {
+		name string
+		do   func(c *gerritSubmissionCache) bool
+		want bool
+	}
+	claim := func(merged int) func(c *gerritSubmissionCache) bool {
+		return func(c *gerritSubmissionCache) bool {
+			return c.claim(merged, submission)
+		}
+	}
+	release := func(c *gerritSubmissionCache) bool {
+		c.release(submission)
+		return true
+	}
+	expire := func(c *gerritSubmissionCache) bool {
+		for _, claim := range c.claimed {
+			claim.at = claim.at.Add(-gerritSubmissionTTL - time.Second)
+		}
+		return true
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "other changes in the submission are skipped",
+			steps: []step{
+				{name: "claim by 2", do: claim(2), want: true},
+				{name: "claim by 1", do: claim(1), want: false},
+				{name: "claim by 3", do: claim(3), want: false},
+			},
+		},
+		{
+			name: "redelivered event claims again",
+			steps: []step{
+				{name: "claim by 2", do: claim(2), want: true},
+				{name: "claim by 2 again", do: claim(2), want: true},
+			},
+		},
+		{
+			name: "released submission is claimed again",
+			steps: []step{
+				{name: "claim by 2", do: claim(2), want: true},
+				{name: "release", do: release, want: true},
+				{name: "claim by 1", do: claim(1), want: true},
+			},
+		},
+		{
+			name: "expired claim is claimed again",
+			steps: []step{
+				{name: "claim by 2", do: claim(2), want: true},
+				{name: "expire", do: expire, want: true},
+				{name: "claim by 1", do: claim(1), want: true},
+			},
+		},
+		{
+			name: "event without change number always claims",
+			steps: []step{
+				{name: "claim by 2", do: claim(2), want: true},
+				{name: "claim by 0", do: claim(0), want: true},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &gerritSubmissionCache{}
+			for _, s := range tc.steps {
+				if got := s.do(c); got != s.want {
+					t.Errorf("%s: expect %v, got %v", s.name, s.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchContent(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		fileName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/a/projects/db/commits/head/files/"), "/content")
+		switch {
+		case strings.HasPrefix(fileName, "missing"):
+			http.NotFound(w, r)
+		case strings.HasPrefix(fileName, "large"):
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("-", 101)))))
+		case strings.HasPrefix(fileName, "binary"):
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0xff})))
+		default:
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte("SELECT 1; -- " + fileName))))
+		}
+	}))
+	defer server.Close()
+
+	concurrency, maxFileSize := gerritFetchConcurrency, gerritMaxFileSize
+	t.Cleanup(func() {
+		gerritFetchConcurrency, gerritMaxFileSize = concurrency, maxFileSize
+	})
+	gerritFetchConcurrency, gerritMaxFileSize = 2, 100
+	s := service.NewGerrit(server.URL, "", "")
+	getContent := func(fileName string) (string, error) {
+		return s.GetFileContentInCommit(context.Background(), "db", "head", fileName)
+	}
+
+	tests := []struct {
+		name         string
+		files        []string
+		wantErr      []string
+		wantRejected bool
+	}{
+		{
+			name:  "all fetched",
+			files: []string{"a.sql", "b.sql", "c.sql", "d.sql", "e.sql"},
+		},
+		{
+			name:    "partial failures",
+			files:   []string{"a.sql", "missing1.sql", "b.sql", "missing2.sql", "large.sql"},
+			wantErr: []string{"failed to fetch 2 of 5 SQL files", `"missing1.sql"`, `"missing2.sql"`},
+		},
+		{
+			name:         "rejected",
+			files:        []string{"a.sql", "large.sql", "binary.sql"},
+			wantErr:      []string{"rejected 2 SQL files", `"binary.sql"`, `"large.sql": 101 bytes exceeds the max file size 100 bytes`},
+			wantRejected: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt32(&maxInFlight, 0)
+			var fileList []*payload.GerritChangedFile
+			for _, fileName := range tc.files {
+				fileList = append(fileList, &payload.GerritChangedFile{FileName: fileName, Status: payload.GerritFileAdded})
+			}
+			// The renamed files are not fetched.
+			fileList = append(fileList, &payload.GerritChangedFile{FileName: "missing-renamed.sql", Status: payload.GerritFileRenamed})
+
+			err := fetchContent(fileList, getContent)
+			if len(tc.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if err == nil {
+					t.Fatalf("Expect error %v", tc.wantErr)
+				}
+				for _, want := range tc.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("Expect %q in the error, got %q", want, err)
+					}
+				}
+			}
+			if isRejectedFiles(err) != tc.wantRejected {
+				t.Errorf("Expect rejected %v, got %v", tc.wantRejected, err)
+			}
+			// Every file is attempted despite the failures.
+			if fileList[0].Content != "SELECT 1; -- a.sql" {
+				t.Errorf("Expect the content of a.sql, got %q", fileList[0].Content)
+			}
+			if got := atomic.LoadInt32(&maxInFlight); got > 2 {
+				t.Errorf("Expect at most 2 concurrent fetches, got %d", got)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 
 	"github.com/bytebase/relay/payload"
@@ -15,11 +16,19 @@ var (
 )
 
 var (
-	refPrefix string
+	refPrefix    string
+	tagRefPrefix string
+	conclusions  []string
+	ciBranches   []string
 )
 
+const githubTagRefPrefix = "refs/tags/"
+
 func init() {
 	flag.StringVar(&refPrefix, "github-ref-prefix", "refs/heads/", "The prefix for the GitHub ref")
+	flag.StringVar(&tagRefPrefix, "github-tag-prefix", "refs/tags/", "The prefix for the GitHub tag ref, tag pushes and release events not matching the prefix are skipped")
+	flag.StringSliceVar(&conclusions, "github-conclusions", nil, "A comma separated list of CI conclusions to relay for workflow_run, check_suite and deployment_status events, e.g. failure,timed_out. Relay all conclusions if empty")
+	flag.StringSliceVar(&ciBranches, "github-ci-branches", nil, "A comma separated list of the branch names or glob patterns to relay workflow_run, check_suite and deployment_status events for, e.g. main,release/*. Relay all branches if empty")
 }
 
 // NewGitHub creates a GitHub hooker
@@ -31,43 +40,179 @@ type githubHooker struct {
 }
 
 func (hooker *githubHooker) handler() (func(r *http.Request) Response, error) {
+	for _, pattern := range ciBranches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --github-ci-branches pattern %q: %w", pattern, err)
+		}
+	}
 	return func(r *http.Request) Response {
 		event := r.Header.Get("X-GitHub-Event")
-		if event == "ping" {
+		switch event {
+		case "ping":
 			return Response{
 				httpCode: http.StatusAccepted,
 				detail:   "Pong",
 			}
-		}
-
-		if event != "push" {
-			return Response{
-				httpCode: http.StatusBadRequest,
-				detail:   "Not a push event",
+		case "push":
+			var p payload.GitHubPushEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			if resp, skip := skipRef(p.Ref); skip {
+				return resp
 			}
-		}
-
-		var payload payload.GitHubPushEvent
-		err := json.NewDecoder(r.Body).Decode(&payload)
-		if err != nil {
 			return Response{
-				httpCode: http.StatusInternalServerError,
-				detail:   fmt.Sprintf("Failed to decode request body: %q", err),
+				httpCode: http.StatusOK,
+				payload:  p,
 			}
-		}
-
-		if !strings.HasPrefix(payload.Ref, refPrefix) {
-			// We don't want to fail the delivery entirely since it would make the webhook
-			// look like not working on the GitHub interface.
+		case "create", "delete":
+			// Every ref creation and deletion sends a push event with created or deleted set as well,
+			// which is relayed instead, otherwise the sinks would receive the same change twice.
 			return Response{
 				httpCode: http.StatusAccepted,
-				detail:   fmt.Sprintf(`The ref %q does not have the required prefix %q`, payload.Ref, refPrefix),
+				detail:   fmt.Sprintf("Skip %s event, relayed by the push event", event),
+			}
+		case "release":
+			var p payload.GitHubReleaseEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			if p.Action != payload.GitHubReleasePublished {
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip release %s event", p.Action),
+				}
+			}
+			if resp, skip := skipRef(githubTagRefPrefix + p.Release.TagName); skip {
+				return resp
+			}
+			return Response{
+				httpCode: http.StatusOK,
+				payload:  p,
+			}
+		case "workflow_run":
+			var p payload.GitHubWorkflowRunEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			if p.Action != "completed" {
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip workflow_run %s event", p.Action),
+				}
+			}
+			if resp, skip := skipCI(p.WorkflowRun.HeadBranch, p.WorkflowRun.Conclusion); skip {
+				return resp
+			}
+			return Response{
+				httpCode: http.StatusOK,
+				payload:  p,
+			}
+		case "check_suite":
+			var p payload.GitHubCheckSuiteEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			if p.Action != "completed" {
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip check_suite %s event", p.Action),
+				}
+			}
+			if resp, skip := skipCI(p.CheckSuite.HeadBranch, p.CheckSuite.Conclusion); skip {
+				return resp
+			}
+			return Response{
+				httpCode: http.StatusOK,
+				payload:  p,
+			}
+		case "deployment_status":
+			var p payload.GitHubDeploymentStatusEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			switch p.DeploymentStatus.State {
+			case "success", "failure", "error", "inactive":
+			default:
+				// Skip the intermediate states such as pending, queued and in_progress.
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip deployment_status %s event", p.DeploymentStatus.State),
+				}
+			}
+			if resp, skip := skipCI(p.Deployment.Ref, p.DeploymentStatus.State); skip {
+				return resp
+			}
+			return Response{
+				httpCode: http.StatusOK,
+				payload:  p,
 			}
 		}
 
 		return Response{
-			httpCode: http.StatusOK,
-			payload:  payload,
+			httpCode: http.StatusBadRequest,
+			detail:   fmt.Sprintf("Unsupported event %q", event),
 		}
 	}, nil
 }
+
+func decodeFailure(err error) Response {
+	return Response{
+		httpCode: http.StatusInternalServerError,
+		detail:   fmt.Sprintf("Failed to decode request body: %q", err),
+	}
+}
+
+// skipRef reports whether the event on the ref should be skipped. Tag refs are
+// checked against --github-tag-prefix, all other refs against --github-ref-prefix.
+func skipRef(ref string) (Response, bool) {
+	prefix := refPrefix
+	if strings.HasPrefix(ref, githubTagRefPrefix) {
+		prefix = tagRefPrefix
+	}
+	if strings.HasPrefix(ref, prefix) {
+		return Response{}, false
+	}
+	// We don't want to fail the delivery entirely since it would make the webhook
+	// look like not working on the GitHub interface.
+	return Response{
+		httpCode: http.StatusAccepted,
+		detail:   fmt.Sprintf(`The ref %q does not have the required prefix %q`, ref, prefix),
+	}, true
+}
+
+// skipCI reports whether the CI event on the ref with the conclusion should be skipped. The ref is
+// the head branch of the workflow run and the check suite, or the ref of the deployment, which may be
+// a branch, a tag or a commit SHA, and is matched against --github-ci-branches as is.
+func skipCI(ref, conclusion string) (Response, bool) {
+	if len(ciBranches) > 0 && !matchCIBranch(ref) {
+		return Response{
+			httpCode: http.StatusAccepted,
+			detail:   fmt.Sprintf("Skip the ref %q, --github-ci-branches is %q", ref, strings.Join(ciBranches, ",")),
+		}, true
+	}
+	if len(conclusions) == 0 {
+		return Response{}, false
+	}
+	for _, c := range conclusions {
+		if strings.EqualFold(c, conclusion) {
+			return Response{}, false
+		}
+	}
+	return Response{
+		httpCode: http.StatusAccepted,
+		detail:   fmt.Sprintf("Skip the %q conclusion, --github-conclusions is %q", conclusion, strings.Join(conclusions, ",")),
+	}, true
+}
+
+// matchCIBranch reports whether the ref matches any of --github-ci-branches, a glob "*" does not
+// match "/".
+func matchCIBranch(ref string) bool {
+	for _, pattern := range ciBranches {
+		// The patterns are validated when the handler is created.
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
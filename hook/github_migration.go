@@ -0,0 +1,254 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/service"
+	"github.com/pkg/errors"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	_                     GitHubMigrationHooker = (*githubMigrationHooker)(nil)
+	githubAPIURL          string
+	githubToken           string
+	githubMigrationBranch string
+	githubMigrationEvent  string
+	githubAppID           int64
+	githubInstallationID  int64
+	githubAppKeyFile      string
+	githubMaxFileSize     int64
+)
+
+const (
+	githubBranchRefPrefix = "refs/heads/"
+	// githubNullSHA is the before SHA of the push creating the branch.
+	githubNullSHA = "0000000000000000000000000000000000000000"
+)
+
+func init() {
+	flag.StringVar(&githubAPIURL, "github-api-url", "https://api.github.com", "The GitHub REST API URL, e.g. https://github.example.com/api/v3 for GitHub Enterprise")
+	flag.StringVar(&githubToken, "github-token", "", "The GitHub token used to fetch the SQL file content")
+	flag.StringVar(&githubMigrationBranch, "github-migration-branch", "main", "The branch whose SQL file changes are sent to Bytebase")
+	flag.StringVar(&githubMigrationEvent, "github-migration-event", "push", "The GitHub event which triggers the SQL migration, either push or pull_request")
+	flag.Int64Var(&githubMaxFileSize, "github-max-file-size", 1<<20, "The max size in bytes of a SQL file, the larger files are rejected, 0 for no limit")
+
+	flag.Int64Var(&githubAppID, "github-app-id", 0, "The GitHub App ID, authenticate as the GitHub App installation instead of using --github-token if set")
+	flag.Int64Var(&githubInstallationID, "github-app-installation-id", 0, "The installation ID of the GitHub App")
+	flag.StringVar(&githubAppKeyFile, "github-app-private-key-file", "", "The path of the PEM encoded GitHub App private key")
+}
+
+// GitHubMigrationHooker is the GitHub hooker relaying the SQL files, which also posts the comments back.
+type GitHubMigrationHooker interface {
+	Hooker
+	// CreateCommitComment creates a comment on a commit, repo is in the form of "owner/name".
+	CreateCommitComment(ctx context.Context, repo, sha string, comment *payload.GitHubCommitCommentInput) error
+}
+
+// NewGitHubMigration creates a GitHub hooker which collects the SQL files changed on the migration branch.
+func NewGitHubMigration() GitHubMigrationHooker {
+	return &githubMigrationHooker{}
+}
+
+type githubMigrationHooker struct {
+	githubService *service.GitHubService
+}
+
+func (hooker *githubMigrationHooker) CreateCommitComment(ctx context.Context, repo, sha string, comment *payload.GitHubCommitCommentInput) error {
+	if hooker.githubService == nil {
+		return fmt.Errorf("the GitHub migration hook is not mounted")
+	}
+	return hooker.githubService.CreateCommitComment(ctx, repo, sha, comment)
+}
+
+func (hooker *githubMigrationHooker) handler() (func(r *http.Request) Response, error) {
+	if githubMigrationEvent != "push" && githubMigrationEvent != "pull_request" {
+		return nil, fmt.Errorf("invalid --github-migration-event %q, must be push or pull_request", githubMigrationEvent)
+	}
+	if githubAppID != 0 {
+		if githubInstallationID == 0 {
+			return nil, fmt.Errorf("--github-app-installation-id is required with --github-app-id")
+		}
+		key, err := os.ReadFile(githubAppKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --github-app-private-key-file: %w", err)
+		}
+		s, err := service.NewGitHubApp(githubAPIURL, githubAppID, githubInstallationID, key)
+		if err != nil {
+			return nil, err
+		}
+		hooker.githubService = s
+	} else {
+		hooker.githubService = service.NewGitHub(githubAPIURL, githubToken)
+	}
+	hooker.githubService.SetMaxFileSize(githubMaxFileSize)
+
+	return func(r *http.Request) Response {
+		if githubToken == "" && githubAppID == 0 {
+			return Response{
+				httpCode: http.StatusAccepted,
+				detail:   "Skip, neither --github-token nor --github-app-id is set",
+			}
+		}
+
+		event := r.Header.Get("X-GitHub-Event")
+		if event == "ping" {
+			return Response{
+				httpCode: http.StatusAccepted,
+				detail:   "Pong",
+			}
+		}
+		if event != githubMigrationEvent {
+			return Response{
+				httpCode: http.StatusAccepted,
+				detail:   fmt.Sprintf("Skip %s event, --github-migration-event is %s", event, githubMigrationEvent),
+			}
+		}
+
+		var repo, ref string
+		var fileList []*service.GitHubFile
+		switch event {
+		case "push":
+			var p payload.GitHubPushEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			if p.Ref != githubBranchRefPrefix+githubMigrationBranch || p.Deleted {
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip the push to %q", p.Ref),
+				}
+			}
+			repo, ref = p.Repository.FullName, p.After
+			if p.Created || p.Before == githubNullSHA {
+				// There is no commit to compare with for the push creating the branch.
+				fileList = pushedFileList(p.Commits)
+				break
+			}
+			// The comparison tells the renames from the removals and the additions, unlike the commits.
+			files, err := hooker.githubService.CompareCommits(r.Context(), repo, p.Before, p.After)
+			if err != nil {
+				return Response{
+					httpCode: http.StatusInternalServerError,
+					detail:   err.Error(),
+				}
+			}
+			fileList = files
+		case "pull_request":
+			var p payload.GitHubPullRequestEvent
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				return decodeFailure(err)
+			}
+			if p.Action != "closed" || !p.PullRequest.Merged {
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip pull request %s event", p.Action),
+				}
+			}
+			if p.PullRequest.Base.Ref != githubMigrationBranch {
+				return Response{
+					httpCode: http.StatusAccepted,
+					detail:   fmt.Sprintf("Skip the pull request merged into %q", p.PullRequest.Base.Ref),
+				}
+			}
+			repo, ref = p.Repository.FullName, p.PullRequest.MergeCommitSHA
+			files, err := hooker.githubService.ListPullRequestFiles(r.Context(), repo, p.Number)
+			if err != nil {
+				return Response{
+					httpCode: http.StatusInternalServerError,
+					detail:   err.Error(),
+				}
+			}
+			fileList = files
+		}
+
+		changedFileList := []*payload.GitHubChangedFile{}
+		for _, file := range fileList {
+			if !strings.HasSuffix(file.Filename, ".sql") || file.Status == payload.GitHubFileRemoved || file.Status == payload.GitHubFileUnchanged {
+				continue
+			}
+			content, err := hooker.githubService.GetFileContent(r.Context(), repo, ref, file.Filename)
+			if errors.Is(err, service.ErrFileTooLarge) {
+				// Retrying does not help.
+				return Response{
+					httpCode: http.StatusUnprocessableEntity,
+					detail:   fmt.Sprintf("Rejected %q: exceeds the max file size %d bytes", file.Filename, githubMaxFileSize),
+				}
+			}
+			if err != nil {
+				return Response{
+					httpCode: http.StatusInternalServerError,
+					detail:   err.Error(),
+				}
+			}
+
+			changedFileList = append(changedFileList, &payload.GitHubChangedFile{
+				FileName:         file.Filename,
+				Content:          content,
+				Status:           file.Status,
+				PreviousFileName: file.PreviousFilename,
+			})
+		}
+		if len(changedFileList) == 0 {
+			return Response{
+				httpCode: http.StatusAccepted,
+				detail:   "Skip, no SQL file is added or modified",
+			}
+		}
+
+		return Response{
+			httpCode: http.StatusOK,
+			payload: payload.GitHubFileChangeMessage{
+				Repository: repo,
+				Ref:        ref,
+				Files:      changedFileList,
+			},
+		}
+	}, nil
+}
+
+// pushedFileList returns the files changed by the commits sorted by the name, squashed to the status
+// against the commit before the first one. The removed files are listed as well. The renames are not
+// known from the commits, and are listed as the removals and the additions.
+func pushedFileList(commits []payload.GitHubCommit) []*service.GitHubFile {
+	statuses := map[string]payload.GitHubFileStatus{}
+	for _, commit := range commits {
+		for _, file := range commit.Added {
+			if statuses[file] == payload.GitHubFileRemoved {
+				// The file existed before the commits, removed and added back.
+				statuses[file] = payload.GitHubFileModified
+				continue
+			}
+			statuses[file] = payload.GitHubFileAdded
+		}
+		for _, file := range commit.Modified {
+			if _, ok := statuses[file]; !ok {
+				statuses[file] = payload.GitHubFileModified
+			}
+		}
+		for _, file := range commit.Removed {
+			if statuses[file] == payload.GitHubFileAdded {
+				// The file did not exist before the commits.
+				delete(statuses, file)
+				continue
+			}
+			statuses[file] = payload.GitHubFileRemoved
+		}
+	}
+
+	var fileList []*service.GitHubFile
+	for file, status := range statuses {
+		fileList = append(fileList, &service.GitHubFile{Filename: file, Status: status})
+	}
+	sort.Slice(fileList, func(i, j int) bool {
+		return fileList[i].Filename < fileList[j].Filename
+	})
+	return fileList
+}
@@ -0,0 +1,180 @@
+package hook
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bytebase/relay/payload"
+)
+
+func TestPushedFileList(t *testing.T) {
+	commits := []payload.GitHubCommit{
+		{Added: []string{"db/002.sql", "db/003.sql"}, Modified: []string{"db/001.sql"}},
+		{Modified: []string{"db/002.sql"}, Removed: []string{"db/003.sql", "db/000.sql"}},
+		{Added: []string{"db/000.sql"}, Removed: []string{"db/004.sql"}},
+	}
+
+	var got []string
+	for _, file := range pushedFileList(commits) {
+		got = append(got, fmt.Sprintf("%s:%s", file.Filename, file.Status))
+	}
+	// 002 is added then modified, 003 is added then removed, and 000 is removed then added back.
+	want := "[db/000.sql:modified db/001.sql:modified db/002.sql:added db/004.sql:removed]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expect %s, got %v", want, got)
+	}
+}
+
+func TestGitHubMigrationHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/bytebase/db/compare/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/before...after") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"files":[
+			{"filename":"prod/orders##002##ddl##add.sql","status":"added"},
+			{"filename":"prod/orders##001##ddl##init.sql","status":"renamed","previous_filename":"prod/orders##001##ddl##create.sql"},
+			{"filename":"prod/orders##000##ddl##drop.sql","status":"removed"},
+			{"filename":"README.md","status":"modified"}
+		]}`))
+	})
+	mux.HandleFunc("/repos/bytebase/db/pulls/7/files", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"filename":"prod/orders##003##dml##seed.sql","status":"modified"}]`))
+	})
+	mux.HandleFunc("/repos/bytebase/db/contents/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "-- %s at %s", strings.TrimPrefix(r.URL.Path, "/repos/bytebase/db/contents/"), r.URL.Query().Get("ref"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	apiURL, token, branch, event, maxFileSize := githubAPIURL, githubToken, githubMigrationBranch, githubMigrationEvent, githubMaxFileSize
+	t.Cleanup(func() {
+		githubAPIURL, githubToken, githubMigrationBranch, githubMigrationEvent, githubMaxFileSize = apiURL, token, branch, event, maxFileSize
+	})
+	githubAPIURL, githubToken, githubMigrationBranch = server.URL, "token", "main"
+
+	type test struct {
+		name      string
+		event     string
+		body      string
+		wantCode  int
+		wantFiles []string
+	}
+
+	tests := []test{
+		{
+			name:     "push to another branch",
+			event:    "push",
+			body:     `{"ref":"refs/heads/dev","before":"before","after":"after","repository":{"full_name":"bytebase/db"}}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "push",
+			event:    "push",
+			body:     `{"ref":"refs/heads/main","before":"before","after":"after","repository":{"full_name":"bytebase/db"}}`,
+			wantCode: http.StatusOK,
+			// Only the .sql files not removed, with the status of the comparison.
+			wantFiles: []string{
+				"prod/orders##002##ddl##add.sql:added: -- prod/orders##002##ddl##add.sql at after",
+				"prod/orders##001##ddl##init.sql:renamed:prod/orders##001##ddl##create.sql -- prod/orders##001##ddl##init.sql at after",
+			},
+		},
+		{
+			name:      "push creating the branch",
+			event:     "push",
+			body:      `{"ref":"refs/heads/main","created":true,"before":"0000000000000000000000000000000000000000","after":"after","commits":[{"added":["prod/orders##001##ddl##init.sql","docs/init.md"]}],"repository":{"full_name":"bytebase/db"}}`,
+			wantCode:  http.StatusOK,
+			wantFiles: []string{"prod/orders##001##ddl##init.sql:added: -- prod/orders##001##ddl##init.sql at after"},
+		},
+		{
+			name:     "push without SQL files",
+			event:    "push",
+			body:     `{"ref":"refs/heads/main","created":true,"before":"0000000000000000000000000000000000000000","after":"after","commits":[{"added":["docs/init.md"]}],"repository":{"full_name":"bytebase/db"}}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "opened pull request",
+			event:    "pull_request",
+			body:     `{"action":"opened","number":7,"pull_request":{"base":{"ref":"main"}},"repository":{"full_name":"bytebase/db"}}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "closed pull request not merged",
+			event:    "pull_request",
+			body:     `{"action":"closed","number":7,"pull_request":{"merged":false,"base":{"ref":"main"}},"repository":{"full_name":"bytebase/db"}}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "pull request merged into another branch",
+			event:    "pull_request",
+			body:     `{"action":"closed","number":7,"pull_request":{"merged":true,"merge_commit_sha":"merged","base":{"ref":"dev"}},"repository":{"full_name":"bytebase/db"}}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:      "merged pull request",
+			event:     "pull_request",
+			body:      `{"action":"closed","number":7,"pull_request":{"merged":true,"merge_commit_sha":"merged","base":{"ref":"main"}},"repository":{"full_name":"bytebase/db"}}`,
+			wantCode:  http.StatusOK,
+			wantFiles: []string{"prod/orders##003##dml##seed.sql:modified: -- prod/orders##003##dml##seed.sql at merged"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			githubMigrationEvent = tc.event
+			handler, err := NewGitHubMigration().handler()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/github-migration", strings.NewReader(tc.body))
+			r.Header.Set("X-GitHub-Event", tc.event)
+			resp := handler(r)
+			if resp.httpCode != tc.wantCode {
+				t.Fatalf("Expect %d, got %d %q", tc.wantCode, resp.httpCode, resp.detail)
+			}
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+			message, ok := resp.payload.(payload.GitHubFileChangeMessage)
+			if !ok {
+				t.Fatalf("Unexpected payload %T", resp.payload)
+			}
+			var got []string
+			for _, file := range message.Files {
+				got = append(got, fmt.Sprintf("%s:%s:%s %s", file.FileName, file.Status, file.PreviousFileName, file.Content))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.wantFiles) {
+				t.Errorf("Expect %v, got %v", tc.wantFiles, got)
+			}
+		})
+	}
+
+	// The oversized file is rejected with 422, since retrying does not help.
+	githubMigrationEvent, githubMaxFileSize = "push", 16
+	handler, err := NewGitHubMigration().handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/github-migration", strings.NewReader(`{"ref":"refs/heads/main","before":"before","after":"after","repository":{"full_name":"bytebase/db"}}`))
+	r.Header.Set("X-GitHub-Event", "push")
+	if resp := handler(r); resp.httpCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expect %d for the oversized file, got %d %q", http.StatusUnprocessableEntity, resp.httpCode, resp.detail)
+	}
+	githubMaxFileSize = maxFileSize
+
+	// The event not chosen by --github-migration-event is skipped.
+	githubMigrationEvent = "pull_request"
+	handler, err = NewGitHubMigration().handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/github-migration", strings.NewReader(`{}`))
+	r.Header.Set("X-GitHub-Event", "push")
+	if resp := handler(r); resp.httpCode != http.StatusAccepted {
+		t.Errorf("Expect the push skipped, got %d %q", resp.httpCode, resp.detail)
+	}
+}
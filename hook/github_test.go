@@ -0,0 +1,137 @@
+package hook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitHubHandler(t *testing.T) {
+	type test struct {
+		name     string
+		event    string
+		body     string
+		wantCode int
+	}
+
+	tests := []test{
+		{name: "push", event: "push", body: `{"ref":"refs/heads/main"}`, wantCode: http.StatusOK},
+		{name: "created push", event: "push", body: `{"ref":"refs/heads/feature","created":true}`, wantCode: http.StatusOK},
+		{name: "tag push", event: "push", body: `{"ref":"refs/tags/v1.2.0","created":true}`, wantCode: http.StatusOK},
+		// The creation and deletion are relayed by the push events, not twice.
+		{name: "create", event: "create", body: `{"ref":"feature","ref_type":"branch"}`, wantCode: http.StatusAccepted},
+		{name: "delete", event: "delete", body: `{"ref":"v1.2.0","ref_type":"tag"}`, wantCode: http.StatusAccepted},
+		{name: "published release", event: "release", body: `{"action":"published","release":{"tag_name":"v1.2.0"}}`, wantCode: http.StatusOK},
+		{name: "created release", event: "release", body: `{"action":"created","release":{"tag_name":"v1.2.0"}}`, wantCode: http.StatusAccepted},
+		{name: "completed workflow run", event: "workflow_run", body: `{"action":"completed","workflow_run":{"head_branch":"main","conclusion":"failure"}}`, wantCode: http.StatusOK},
+		{name: "requested workflow run", event: "workflow_run", body: `{"action":"requested","workflow_run":{"head_branch":"main"}}`, wantCode: http.StatusAccepted},
+		{name: "completed check suite", event: "check_suite", body: `{"action":"completed","check_suite":{"head_branch":"main","conclusion":"success"}}`, wantCode: http.StatusOK},
+		{name: "final deployment status", event: "deployment_status", body: `{"deployment_status":{"state":"failure"},"deployment":{"ref":"v1.2.0"}}`, wantCode: http.StatusOK},
+		{name: "pending deployment status", event: "deployment_status", body: `{"deployment_status":{"state":"pending"},"deployment":{"ref":"main"}}`, wantCode: http.StatusAccepted},
+		{name: "malformed body", event: "push", body: `{`, wantCode: http.StatusInternalServerError},
+		{name: "unsupported event", event: "issues", body: `{}`, wantCode: http.StatusBadRequest},
+	}
+
+	handler, err := NewGitHub().handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/github", strings.NewReader(tc.body))
+			r.Header.Set("X-GitHub-Event", tc.event)
+			resp := handler(r)
+			if resp.httpCode != tc.wantCode {
+				t.Errorf("Expect %d, got %d %q", tc.wantCode, resp.httpCode, resp.detail)
+			}
+			if (resp.payload != nil) != (tc.wantCode == http.StatusOK) {
+				t.Errorf("Expect the payload only relayed with %d, got %v", http.StatusOK, resp.payload)
+			}
+		})
+	}
+}
+
+func TestSkipRef(t *testing.T) {
+	prefix, tagPrefix := refPrefix, tagRefPrefix
+	t.Cleanup(func() {
+		refPrefix, tagRefPrefix = prefix, tagPrefix
+	})
+	refPrefix, tagRefPrefix = "refs/heads/main", "refs/tags/v"
+
+	type test struct {
+		ref      string
+		wantSkip bool
+	}
+
+	tests := []test{
+		{ref: "refs/heads/main"},
+		{ref: "refs/heads/feature", wantSkip: true},
+		// The tags are checked against --github-tag-prefix only.
+		{ref: "refs/tags/v1.2.0"},
+		{ref: "refs/tags/nightly", wantSkip: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			resp, skip := skipRef(tc.ref)
+			if skip != tc.wantSkip {
+				t.Fatalf("Expect skip %v, got %v", tc.wantSkip, skip)
+			}
+			if skip && resp.httpCode != http.StatusAccepted {
+				t.Errorf("Expect %d for the skipped ref, got %d", http.StatusAccepted, resp.httpCode)
+			}
+		})
+	}
+}
+
+func TestSkipCI(t *testing.T) {
+	branches, conclusionList := ciBranches, conclusions
+	t.Cleanup(func() {
+		ciBranches, conclusions = branches, conclusionList
+	})
+
+	type test struct {
+		branches    []string
+		conclusions []string
+		ref         string
+		conclusion  string
+		wantSkip    bool
+	}
+
+	tests := []test{
+		{ref: "feature", conclusion: "success"},
+		{conclusions: []string{"failure", "timed_out"}, ref: "main", conclusion: "FAILURE"},
+		{conclusions: []string{"failure", "timed_out"}, ref: "main", conclusion: "success", wantSkip: true},
+		{branches: []string{"main"}, conclusions: []string{"failure"}, ref: "main", conclusion: "failure"},
+		// The names match exactly, not by prefix.
+		{branches: []string{"main"}, ref: "main-foo", conclusion: "failure", wantSkip: true},
+		{branches: []string{"main", "release/*"}, ref: "release/1.2", conclusion: "success"},
+		{branches: []string{"release/*"}, ref: "release/1.2/hotfix", conclusion: "success", wantSkip: true},
+		// The deployment refs are matched as is, e.g. a tag or a commit SHA.
+		{branches: []string{"main"}, ref: "v1.2.0", conclusion: "success", wantSkip: true},
+		{branches: []string{"v*"}, ref: "v1.2.0", conclusion: "success"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ref+" "+tc.conclusion, func(t *testing.T) {
+			ciBranches, conclusions = tc.branches, tc.conclusions
+			resp, skip := skipCI(tc.ref, tc.conclusion)
+			if skip != tc.wantSkip {
+				t.Fatalf("Expect skip %v, got %v %q", tc.wantSkip, skip, resp.detail)
+			}
+			if skip && resp.httpCode != http.StatusAccepted {
+				t.Errorf("Expect %d for the skipped event, got %d", http.StatusAccepted, resp.httpCode)
+			}
+		})
+	}
+}
+
+func TestGitHubHandlerInvalidCIBranches(t *testing.T) {
+	branches := ciBranches
+	t.Cleanup(func() { ciBranches = branches })
+	ciBranches = []string{"release/["}
+	if _, err := NewGitHub().handler(); err == nil {
+		t.Error("Expect error for the invalid --github-ci-branches pattern")
+	}
+}
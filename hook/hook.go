@@ -1,6 +1,7 @@
 package hook
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync"
@@ -28,6 +29,13 @@ type Hooker interface {
 	handler() (func(r *http.Request) Response, error)
 }
 
+// streamer is implemented by the hooker which also receives events from a source other than the webhook.
+// Each response is dispatched to the sink list in the same way as the webhook response.
+type streamer interface {
+	// stream consumes the events until ctx is done, it returns immediately if the source is not configured.
+	stream(ctx context.Context, dispatch func(ctx context.Context, resp Response) (int, string))
+}
+
 var (
 	hookersMu sync.RWMutex
 	hookers   = make(map[string]Hooker)
@@ -40,8 +48,10 @@ var (
 // - If you want the hook handler at /foo to pass the payload to sink [bar, baz], then
 // you pass the [bar, baz] sink list.
 //
-// e.g  hook.Mount(fs, f, "/foo", fooHook, [barSink, bazSink])
-func Mount(f *flamego.Flame, path string, h Hooker, ss []sink.Sinker) {
+// - If the hooker also streams events from another source, it consumes them until ctx is done.
+//
+// e.g  hook.Mount(ctx, f, "/foo", fooHook, [barSink, bazSink])
+func Mount(ctx context.Context, f *flamego.Flame, path string, h Hooker, ss []sink.Sinker) {
 	if h == nil {
 		panic("hook: Mount hooker is nil")
 	}
@@ -62,23 +72,36 @@ func Mount(f *flamego.Flame, path string, h Hooker, ss []sink.Sinker) {
 		}
 	}
 
-	f.Post(path, func(r *http.Request) (int, string) {
-		resp := handler(r)
-
+	dispatch := func(ctx context.Context, resp Response) (int, string) {
 		if resp.httpCode == http.StatusOK {
+			ctx, report := sink.WithReport(ctx)
 			var result error
 			for _, s := range ss {
-				if err := s.Process(r.Context(), path, resp.payload); err != nil {
+				if err := s.Process(ctx, path, resp.payload); err != nil {
 					result = multierror.Append(result, err)
 				}
 			}
+			if !report.Empty() {
+				fmt.Printf("Sink %q report: %s\n", path, report)
+			}
 			if result != nil {
-				return http.StatusInternalServerError, fmt.Sprintf("Encountered error send to sink %q: %v", path, err)
+				return http.StatusInternalServerError, fmt.Sprintf("Encountered error send to sink %q: %v", path, result)
+			}
+			if !report.Empty() {
+				return http.StatusOK, fmt.Sprintf("OK %s", report)
 			}
 			return http.StatusOK, "OK"
 		}
 		return resp.httpCode, resp.detail
+	}
+
+	f.Post(path, func(r *http.Request) (int, string) {
+		return dispatch(r.Context(), handler(r))
 	})
 
+	if s, ok := h.(streamer); ok {
+		go s.stream(ctx, dispatch)
+	}
+
 	hookers[path] = h
 }
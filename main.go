@@ -10,6 +10,7 @@ import (
 	"syscall"
 
 	"github.com/bytebase/relay/hook"
+	"github.com/bytebase/relay/route"
 	"github.com/bytebase/relay/sink"
 	"github.com/flamego/flamego"
 	flag "github.com/spf13/pflag"
@@ -51,8 +52,14 @@ func init() {
 }
 
 func main() {
+	// The flags after the command are the flags of the command.
+	flag.CommandLine.SetInterspersed(false)
 	flag.Parse()
 
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(args))
+	}
+
 	h := "localhost"
 	p := 5678
 	if address != "" {
@@ -65,17 +72,22 @@ func main() {
 		}
 		p = port
 	}
+	// The context is cancelled on shutdown, which stops the event streams.
+	ctx, cancel := context.WithCancel(context.Background())
+
 	f := flamego.Classic()
 	github := hook.NewGitHub()
 	lark := sink.NewLark()
-	hook.Mount(f, "/github", github, []sink.Sinker{lark})
+	hook.Mount(ctx, f, "/github", github, []sink.Sinker{lark})
 
 	gerrit := hook.NewGerrit()
-	bytebase := sink.NewBytebase()
-	hook.Mount(f, "/gerrit", gerrit, []sink.Sinker{bytebase})
+	githubMigration := hook.NewGitHubMigration()
+	bytebase := sink.NewBytebase(gerrit, githubMigration)
+	hook.Mount(ctx, f, "/gerrit", gerrit, []sink.Sinker{bytebase})
+
+	hook.Mount(ctx, f, "/github-migration", githubMigration, []sink.Sinker{bytebase})
 
 	// Setup signal handlers.
-	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	// Trigger graceful shutdown on SIGINT or SIGTERM.
 	// The default signal sent by the `kill` command is SIGTERM,
@@ -96,3 +108,64 @@ func main() {
 	// Wait for CTRL-C.
 	<-ctx.Done()
 }
+
+// runCommand runs the command instead of the server, e.g. relay bytebase parse <path>..., and returns the exit code.
+func runCommand(args []string) int {
+	const usage = "relay [flags] bytebase parse [--route <name> | --project <project> --branch <branch>] <path>..."
+	if len(args) < 2 || args[0] != "bytebase" || args[1] != "parse" {
+		fmt.Printf("Unknown command %q, usage: %s\n", strings.Join(args, " "), usage)
+		return 2
+	}
+
+	parseFlags := flag.NewFlagSet("bytebase parse", flag.ContinueOnError)
+	routeName := parseFlags.String("route", "", "The name of the route in --gerrit-routes to parse with")
+	project := parseFlags.String("project", "", "The Gerrit project to find the route to parse with")
+	branch := parseFlags.String("branch", "", "The Gerrit branch to find the route to parse with, required with --project")
+	if err := parseFlags.Parse(args[2:]); err != nil {
+		fmt.Printf("%v, usage: %s\n", err, usage)
+		return 2
+	}
+	if parseFlags.NArg() == 0 {
+		fmt.Printf("No file path to parse, usage: %s\n", usage)
+		return 2
+	}
+
+	r, err := parseRoute(*routeName, *project, *branch)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := sink.ParseFilePaths(os.Stdout, r, parseFlags.Args()); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// parseRoute returns the route named name, or matching the project and branch, in the Gerrit routes.
+// It returns nil if neither is set, to parse with the Bytebase flags only.
+func parseRoute(name, project, branch string) (*route.Route, error) {
+	if name == "" && project == "" {
+		return nil, nil
+	}
+	routes, err := hook.GerritRoutes()
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		for _, r := range routes {
+			if r.Name == name {
+				return r, nil
+			}
+		}
+		return nil, fmt.Errorf("route %q not found", name)
+	}
+	if branch == "" {
+		return nil, fmt.Errorf("--branch is required with --project")
+	}
+	r := route.Find(routes, project, branch)
+	if r == nil {
+		return nil, fmt.Errorf("no route matches %s branch in %s project", branch, project)
+	}
+	return r, nil
+}
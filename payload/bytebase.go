@@ -11,6 +11,10 @@ const (
 	Migrate MigrationType = "MIGRATE"
 	// Used for DML change.
 	Data MigrationType = "DATA"
+	// Used for recording the existing schema as the baseline without applying the statement.
+	Baseline MigrationType = "BASELINE"
+	// Used for the declarative schema file, Bytebase computes the DDL from the desired state.
+	SDL MigrationType = "MIGRATE_SDL"
 )
 
 // IssueCreate is the API message for creating a issue.
@@ -26,4 +30,215 @@ type IssueCreate struct {
 	MigrationType MigrationType `json:"migrationType"`
 	Statement     string        `json:"statement"`
 	SchemaVersion string        `json:"schemaVersion"`
+
+	// Target is the database resource name used by the v1 API, e.g. instances/{instance}/databases/{database}.
+	Target string `json:"-"`
+	// FileName is the file holding the statement.
+	FileName string `json:"-"`
+}
+
+// MultiStepIssueCreate is the message for creating an issue applying the changes step by step.
+type MultiStepIssueCreate struct {
+	ProjectKey  string
+	Title       string
+	Description string
+	Steps       []*IssueStepCreate
+}
+
+// IssueStepCreate is a step of MultiStepIssueCreate, the changes are applied in order.
+type IssueStepCreate struct {
+	Title   string
+	Changes []*IssueCreate
+}
+
+// IssueStatus is the status of an issue.
+type IssueStatus string
+
+const (
+	IssueStatusOpen     IssueStatus = "OPEN"
+	IssueStatusDone     IssueStatus = "DONE"
+	IssueStatusCanceled IssueStatus = "CANCELED"
+)
+
+// IssueStatusUpdate is the API message for updating the status of the issues.
+type IssueStatusUpdate struct {
+	Issues []string    `json:"issues"`
+	Status IssueStatus `json:"status"`
+	Reason string      `json:"reason"`
+}
+
+// IssueTypeDatabaseChange is the type of the issue changing the databases.
+const IssueTypeDatabaseChange IssueType = "DATABASE_CHANGE"
+
+// Issue is the API message for an issue.
+type Issue struct {
+	// Name is the issue resource name, e.g. projects/{project}/issues/{issue}.
+	Name        string    `json:"name,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Type        IssueType `json:"type,omitempty"`
+	// Plan is the plan resource name of the issue, e.g. projects/{project}/plans/{plan}.
+	Plan string `json:"plan,omitempty"`
+	// Rollout is the rollout resource name of the issue, e.g. projects/{project}/rollouts/{rollout}.
+	Rollout string      `json:"rollout,omitempty"`
+	Status  IssueStatus `json:"status,omitempty"`
+}
+
+// Sheet is the API message for a sheet holding a statement.
+type Sheet struct {
+	// Name is the sheet resource name, e.g. projects/{project}/sheets/{sheet}.
+	Name  string `json:"name,omitempty"`
+	Title string `json:"title"`
+	// Content is the statement, encoded as base64 in JSON.
+	Content []byte `json:"content"`
+}
+
+// Plan is the API message for a plan, the steps and their specs are applied in order.
+type Plan struct {
+	// Name is the plan resource name, e.g. projects/{project}/plans/{plan}.
+	Name        string      `json:"name,omitempty"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	Steps       []*PlanStep `json:"steps"`
+	// Issue is the issue resource name of the plan, empty if the issue is not created.
+	Issue string `json:"issue,omitempty"`
+}
+
+// ListPlansResponse is the API message for a page of the plans.
+type ListPlansResponse struct {
+	Plans         []*Plan `json:"plans"`
+	NextPageToken string  `json:"nextPageToken"`
+}
+
+// PlanStep is a step of a plan.
+type PlanStep struct {
+	Title string      `json:"title,omitempty"`
+	Specs []*PlanSpec `json:"specs"`
+}
+
+// PlanSpec is a spec of a plan step.
+type PlanSpec struct {
+	// ID is a UUID identifying the spec in the plan.
+	ID                   string                `json:"id"`
+	ChangeDatabaseConfig *ChangeDatabaseConfig `json:"changeDatabaseConfig"`
+}
+
+// ChangeDatabaseConfig is the config of a spec changing a database with a sheet.
+type ChangeDatabaseConfig struct {
+	// Target is the database resource name, e.g. instances/{instance}/databases/{database}.
+	Target string `json:"target"`
+	// Sheet is the sheet resource name holding the statement.
+	Sheet         string        `json:"sheet"`
+	Type          MigrationType `json:"type"`
+	SchemaVersion string        `json:"schemaVersion,omitempty"`
+}
+
+// ChangeHistoryStatus is the status of a change history.
+type ChangeHistoryStatus string
+
+const (
+	ChangeHistoryPending ChangeHistoryStatus = "PENDING"
+	ChangeHistoryDone    ChangeHistoryStatus = "DONE"
+	ChangeHistoryFailed  ChangeHistoryStatus = "FAILED"
+)
+
+// ChangeHistory is the API message for a change applied to a database.
+type ChangeHistory struct {
+	// Name is the change history resource name, e.g. instances/{instance}/databases/{database}/changeHistories/{history}.
+	Name      string              `json:"name"`
+	Status    ChangeHistoryStatus `json:"status"`
+	Type      MigrationType       `json:"type"`
+	Version   string              `json:"version"`
+	Statement string              `json:"statement"`
+	// Issue is the issue resource name applying the change, empty if applied out of Bytebase.
+	Issue string `json:"issue"`
+}
+
+// ListChangeHistoriesResponse is the API message for a page of the change histories.
+type ListChangeHistoriesResponse struct {
+	ChangeHistories []*ChangeHistory `json:"changeHistories"`
+	NextPageToken   string           `json:"nextPageToken"`
+}
+
+// RolloutCreate is the API message for creating the rollout of a plan.
+type RolloutCreate struct {
+	Plan string `json:"plan"`
+}
+
+// ActuatorInfo is the API message for the server information.
+type ActuatorInfo struct {
+	Version string `json:"version"`
+}
+
+// Rollout is the API message for a rollout.
+type Rollout struct {
+	Name string `json:"name"`
+	Plan string `json:"plan"`
+	// Stages are the stages of the rollout, e.g. one per environment, run in order.
+	Stages []*RolloutStage `json:"stages,omitempty"`
+}
+
+// RolloutStage is the API message for a stage of a rollout.
+type RolloutStage struct {
+	// Name is the stage resource name, e.g. projects/{project}/rollouts/{rollout}/stages/{stage}.
+	Name  string         `json:"name"`
+	Tasks []*RolloutTask `json:"tasks"`
+}
+
+// RolloutTaskStatus is the status of a rollout task.
+type RolloutTaskStatus string
+
+const (
+	RolloutTaskNotStarted RolloutTaskStatus = "NOT_STARTED"
+	RolloutTaskPending    RolloutTaskStatus = "PENDING"
+	RolloutTaskRunning    RolloutTaskStatus = "RUNNING"
+	RolloutTaskDone       RolloutTaskStatus = "DONE"
+	RolloutTaskFailed     RolloutTaskStatus = "FAILED"
+	RolloutTaskCanceled   RolloutTaskStatus = "CANCELED"
+	RolloutTaskSkipped    RolloutTaskStatus = "SKIPPED"
+)
+
+// RolloutTask is the API message for a task applying a change to a database.
+type RolloutTask struct {
+	// Name is the task resource name, e.g. projects/{project}/rollouts/{rollout}/stages/{stage}/tasks/{task}.
+	Name   string            `json:"name"`
+	Target string            `json:"target"`
+	Status RolloutTaskStatus `json:"status"`
+}
+
+// BatchRunTasksRequest is the API message for running the tasks of a stage.
+type BatchRunTasksRequest struct {
+	Tasks  []string `json:"tasks"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// SQLCheckRequest is the API message for checking the SQL statement against the SQL review policy.
+type SQLCheckRequest struct {
+	// Name is the database resource name, e.g. instances/{instance}/databases/{database}.
+	Name      string `json:"name"`
+	Statement string `json:"statement"`
+}
+
+// SQLAdviceStatus is the status of a SQL advice.
+type SQLAdviceStatus string
+
+const (
+	SQLAdviceSuccess SQLAdviceStatus = "SUCCESS"
+	SQLAdviceWarning SQLAdviceStatus = "WARNING"
+	SQLAdviceError   SQLAdviceStatus = "ERROR"
+)
+
+// SQLAdvice is a single finding of the SQL check.
+type SQLAdvice struct {
+	Status  SQLAdviceStatus `json:"status"`
+	Code    int             `json:"code"`
+	Title   string          `json:"title"`
+	Content string          `json:"content"`
+	Line    int             `json:"line"`
+	Column  int             `json:"column"`
+}
+
+// SQLCheckResponse is the API message for the SQL check result.
+type SQLCheckResponse struct {
+	Advices []*SQLAdvice `json:"advices"`
 }
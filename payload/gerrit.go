@@ -3,31 +3,138 @@ package payload
 type GerritEventType string
 
 const (
-	GerritEventChangeMerged GerritEventType = "change-merged"
+	GerritEventChangeMerged    GerritEventType = "change-merged"
+	GerritEventPatchSetCreated GerritEventType = "patchset-created"
+	GerritEventRefUpdated      GerritEventType = "ref-updated"
+	GerritEventCommentAdded    GerritEventType = "comment-added"
+)
+
+// GerritChangeStatus is the status of a change.
+type GerritChangeStatus string
+
+const (
+	GerritChangeNew       GerritChangeStatus = "NEW"
+	GerritChangeMerged    GerritChangeStatus = "MERGED"
+	GerritChangeAbandoned GerritChangeStatus = "ABANDONED"
 )
 
 type GerritChange struct {
-	Project string `json:"project"`
-	Branch  string `json:"branch"`
-	ID      string `json:"id"`
+	Project string             `json:"project"`
+	Branch  string             `json:"branch"`
+	ID      string             `json:"id"`
+	Number  int                `json:"number"`
+	Topic   string             `json:"topic"`
+	Status  GerritChangeStatus `json:"status"`
+}
+
+type GerritAccount struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
 }
 
 type GerritPatchSet struct {
 	Revision string `json:"revision"`
 }
 
+type GerritRefUpdate struct {
+	OldRev string `json:"oldRev"`
+	NewRev string `json:"newRev"`
+	// RefName is the full ref name such as "refs/heads/main", or the short branch name on the old Gerrit versions.
+	RefName string `json:"refName"`
+	Project string `json:"project"`
+}
+
 // GerritEvent is the API message for Gerrit webhook.
 type GerritEvent struct {
-	Change   *GerritChange   `json:"change"`
-	Type     GerritEventType `json:"type"`
-	PatchSet *GerritPatchSet `json:"patchSet"`
+	Change    *GerritChange    `json:"change"`
+	Type      GerritEventType  `json:"type"`
+	PatchSet  *GerritPatchSet  `json:"patchSet"`
+	RefUpdate *GerritRefUpdate `json:"refUpdate"`
+	// Author and Comment are set in the comment-added event.
+	Author  *GerritAccount `json:"author"`
+	Comment string         `json:"comment"`
+	// EventCreatedOn is the event creation time in seconds since the epoch.
+	EventCreatedOn int64 `json:"eventCreatedOn"`
 }
 
 type GerritFileChangeMessage struct {
+	// Route is the name of the route matching the project and branch of the change.
+	Route string
+	// Changes are the numbers of the changes submitted together, in the submission order.
+	Changes []int
+	// Files are ordered by the change order.
 	Files []*GerritChangedFile
 }
 
+// GerritFileStatus is the status of a file in a revision.
+type GerritFileStatus string
+
+const (
+	GerritFileAdded     GerritFileStatus = "A"
+	GerritFileModified  GerritFileStatus = "M"
+	GerritFileDeleted   GerritFileStatus = "D"
+	GerritFileRenamed   GerritFileStatus = "R"
+	GerritFileCopied    GerritFileStatus = "C"
+	GerritFileRewritten GerritFileStatus = "W"
+)
+
 type GerritChangedFile struct {
+	// Change is the number of the change containing the file.
+	Change   int
 	FileName string
 	Content  string
+	Status   GerritFileStatus
+	// OldPath is the file path before the rename or copy.
+	OldPath string
+}
+
+// GerritPatchSetCheckMessage is the message for checking the SQL files in a new patch set
+// before the change is merged, the findings are posted back to the change.
+type GerritPatchSetCheckMessage struct {
+	// Route is the name of the route matching the project and branch of the change.
+	Route    string
+	ChangeID string
+	Revision string
+	Files    []*GerritChangedFile
+}
+
+// GerritCommand is the command commented on a change, e.g. "/relay retry".
+type GerritCommand string
+
+const (
+	// GerritCommandRetry creates the Bytebase issues for the merged change again.
+	GerritCommandRetry GerritCommand = "retry"
+	// GerritCommandDryRun previews the Bytebase issues for the change without creating them.
+	GerritCommandDryRun GerritCommand = "dry-run"
+	// GerritCommandApply creates the Bytebase issues for the merged change and rolls them out.
+	GerritCommandApply GerritCommand = "apply"
+)
+
+// GerritCommandMessage is the message for running the command commented on a change,
+// the outcome is replied on the change.
+type GerritCommandMessage struct {
+	// Route is the name of the route matching the project and branch of the change.
+	Route    string
+	Command  GerritCommand
+	ChangeID string
+	Revision string
+	Files    []*GerritChangedFile
+}
+
+// GerritReviewInput is the API message for setting a review on a revision.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#review-input
+type GerritReviewInput struct {
+	Message  string                           `json:"message,omitempty"`
+	Labels   map[string]int                   `json:"labels,omitempty"`
+	Comments map[string][]*GerritCommentInput `json:"comments,omitempty"`
+}
+
+// GerritCommentInput is the API message for an inline comment.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#comment-input
+type GerritCommentInput struct {
+	// Line is 0 for a file comment.
+	Line       int    `json:"line,omitempty"`
+	Message    string `json:"message"`
+	Unresolved bool   `json:"unresolved,omitempty"`
 }
@@ -1,22 +1,209 @@
 package payload
 
+// GitHubReleaseAction is the action of a GitHub release event.
+type GitHubReleaseAction string
+
+const (
+	GitHubReleasePublished GitHubReleaseAction = "published"
+)
+
 type GitHubAuthor struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
 }
 
+type GitHubUser struct {
+	Login string `json:"login"`
+}
+
 type GitHubCommit struct {
+	ID        string       `json:"id"`
 	Message   string       `json:"message"`
 	Timestamp string       `json:"timestamp"`
+	URL       string       `json:"url"`
 	Author    GitHubAuthor `json:"author"`
+	Added     []string     `json:"added"`
+	Removed   []string     `json:"removed"`
+	Modified  []string     `json:"modified"`
+}
+
+type GitHubRepository struct {
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	HTMLURL       string `json:"html_url"`
+	DefaultBranch string `json:"default_branch"`
 }
 
+// GitHubPushEvent is the API message for GitHub push webhook.
+// Docs: https://docs.github.com/en/webhooks/webhook-events-and-payloads#push
 type GitHubPushEvent struct {
-	Ref        string       `json:"ref"`
-	Deleted    bool         `json:"deleted"`
-	Compare    string       `json:"compare"`
-	HeadCommit GitHubCommit `json:"head_commit"`
-	Sender     struct {
-		Login string `json:"login"`
-	} `json:"sender"`
+	Ref        string           `json:"ref"`
+	BaseRef    string           `json:"base_ref"`
+	Before     string           `json:"before"`
+	After      string           `json:"after"`
+	Created    bool             `json:"created"`
+	Deleted    bool             `json:"deleted"`
+	Forced     bool             `json:"forced"`
+	Compare    string           `json:"compare"`
+	Commits    []GitHubCommit   `json:"commits"`
+	HeadCommit GitHubCommit     `json:"head_commit"`
+	Repository GitHubRepository `json:"repository"`
+	Sender     GitHubUser       `json:"sender"`
+}
+
+type GitHubRelease struct {
+	TagName         string     `json:"tag_name"`
+	TargetCommitish string     `json:"target_commitish"`
+	Name            string     `json:"name"`
+	Body            string     `json:"body"`
+	HTMLURL         string     `json:"html_url"`
+	Draft           bool       `json:"draft"`
+	Prerelease      bool       `json:"prerelease"`
+	PublishedAt     string     `json:"published_at"`
+	Author          GitHubUser `json:"author"`
+}
+
+// GitHubReleaseEvent is the API message for GitHub release webhook.
+// Docs: https://docs.github.com/en/webhooks/webhook-events-and-payloads#release
+type GitHubReleaseEvent struct {
+	Action     GitHubReleaseAction `json:"action"`
+	Release    GitHubRelease       `json:"release"`
+	Repository GitHubRepository    `json:"repository"`
+	Sender     GitHubUser          `json:"sender"`
+}
+
+// GitHubWorkflowRun is the workflow run object in the GitHub workflow_run event.
+type GitHubWorkflowRun struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Event        string `json:"event"`
+	Status       string `json:"status"`
+	Conclusion   string `json:"conclusion"`
+	HeadBranch   string `json:"head_branch"`
+	HeadSHA      string `json:"head_sha"`
+	RunNumber    int64  `json:"run_number"`
+	RunAttempt   int64  `json:"run_attempt"`
+	HTMLURL      string `json:"html_url"`
+	RunStartedAt string `json:"run_started_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// GitHubWorkflowRunEvent is the API message for GitHub workflow_run webhook.
+// Docs: https://docs.github.com/en/webhooks/webhook-events-and-payloads#workflow_run
+type GitHubWorkflowRunEvent struct {
+	Action      string            `json:"action"`
+	WorkflowRun GitHubWorkflowRun `json:"workflow_run"`
+	Repository  GitHubRepository  `json:"repository"`
+	Sender      GitHubUser        `json:"sender"`
+}
+
+type GitHubApp struct {
+	Name string `json:"name"`
+}
+
+// GitHubCheckSuite is the check suite object in the GitHub check_suite event.
+type GitHubCheckSuite struct {
+	ID         int64     `json:"id"`
+	Status     string    `json:"status"`
+	Conclusion string    `json:"conclusion"`
+	HeadBranch string    `json:"head_branch"`
+	HeadSHA    string    `json:"head_sha"`
+	App        GitHubApp `json:"app"`
+	CreatedAt  string    `json:"created_at"`
+	UpdatedAt  string    `json:"updated_at"`
+}
+
+// GitHubCheckSuiteEvent is the API message for GitHub check_suite webhook.
+// Docs: https://docs.github.com/en/webhooks/webhook-events-and-payloads#check_suite
+type GitHubCheckSuiteEvent struct {
+	Action     string           `json:"action"`
+	CheckSuite GitHubCheckSuite `json:"check_suite"`
+	Repository GitHubRepository `json:"repository"`
+	Sender     GitHubUser       `json:"sender"`
+}
+
+type GitHubDeployment struct {
+	ID          int64  `json:"id"`
+	Ref         string `json:"ref"`
+	SHA         string `json:"sha"`
+	Environment string `json:"environment"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type GitHubDeploymentStatus struct {
+	State       string `json:"state"`
+	Environment string `json:"environment"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+	LogURL      string `json:"log_url"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// GitHubDeploymentStatusEvent is the API message for GitHub deployment_status webhook.
+// Docs: https://docs.github.com/en/webhooks/webhook-events-and-payloads#deployment_status
+type GitHubDeploymentStatusEvent struct {
+	Action           string                 `json:"action"`
+	Deployment       GitHubDeployment       `json:"deployment"`
+	DeploymentStatus GitHubDeploymentStatus `json:"deployment_status"`
+	Repository       GitHubRepository       `json:"repository"`
+	Sender           GitHubUser             `json:"sender"`
+}
+
+type GitHubBranch struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+type GitHubPullRequest struct {
+	Number         int          `json:"number"`
+	Title          string       `json:"title"`
+	HTMLURL        string       `json:"html_url"`
+	Merged         bool         `json:"merged"`
+	MergeCommitSHA string       `json:"merge_commit_sha"`
+	Base           GitHubBranch `json:"base"`
+	Head           GitHubBranch `json:"head"`
+}
+
+// GitHubPullRequestEvent is the API message for GitHub pull_request webhook.
+// Docs: https://docs.github.com/en/webhooks/webhook-events-and-payloads#pull_request
+type GitHubPullRequestEvent struct {
+	Action      string            `json:"action"`
+	Number      int               `json:"number"`
+	PullRequest GitHubPullRequest `json:"pull_request"`
+	Repository  GitHubRepository  `json:"repository"`
+	Sender      GitHubUser        `json:"sender"`
+}
+
+// GitHubFileChangeMessage is the message for the SQL files changed by a push or a merged pull request.
+type GitHubFileChangeMessage struct {
+	Repository string
+	Ref        string
+	Files      []*GitHubChangedFile
+}
+
+// GitHubFileStatus is the status of a file changed between two commits or by a pull request.
+type GitHubFileStatus string
+
+const (
+	GitHubFileAdded     GitHubFileStatus = "added"
+	GitHubFileRemoved   GitHubFileStatus = "removed"
+	GitHubFileModified  GitHubFileStatus = "modified"
+	GitHubFileRenamed   GitHubFileStatus = "renamed"
+	GitHubFileCopied    GitHubFileStatus = "copied"
+	GitHubFileChanged   GitHubFileStatus = "changed"
+	GitHubFileUnchanged GitHubFileStatus = "unchanged"
+)
+
+type GitHubChangedFile struct {
+	FileName string
+	Content  string
+	Status   GitHubFileStatus
+	// PreviousFileName is the file path before the rename or copy.
+	PreviousFileName string
+}
+
+// GitHubCommitCommentInput is the API message for creating a comment on a commit.
+// Docs: https://docs.github.com/en/rest/commits/comments#create-a-commit-comment
+type GitHubCommitCommentInput struct {
+	Body string `json:"body"`
 }
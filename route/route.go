@@ -0,0 +1,399 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix marks a pattern as a regular expression instead of a glob pattern.
+const regexPrefix = "re:"
+
+// DefaultFileInclude is the file pattern included if Files.Include is empty, i.e. every SQL file.
+const DefaultFileInclude = "**/*.sql"
+
+// Route routes the changes of the matching Gerrit projects and branches to Bytebase.
+type Route struct {
+	// Name identifies the route in logs, responses and the relayed messages, unique in the route file,
+	// default to the index in the route file.
+	Name string `json:"name"`
+	// Projects and Branches are the glob patterns matching the Gerrit project and branch names,
+	// or the regular expressions if prefixed with "re:", e.g. "re:^release-[0-9.]+$".
+	// A glob "*" does not match "/", e.g. "db/*" matches "db/foo" but not "db/foo/bar".
+	Projects []string `json:"projects"`
+	Branches []string `json:"branches"`
+
+	// Files selects the files relayed by the route, default to every SQL file.
+	Files Files `json:"files"`
+
+	Bytebase Bytebase `json:"bytebase"`
+
+	projects       []matcher
+	branches       []matcher
+	include        []matcher
+	exclude        []matcher
+	template       *Template
+	schemaTemplate *Template
+}
+
+// Files is the file selection of a route.
+type Files struct {
+	// Include and Exclude are the glob patterns matching the file paths, or the regular expressions
+	// if prefixed with "re:". A glob "**" matches any number of directories, e.g. "migrations/**/*.sql"
+	// matches "migrations/1.sql" and "migrations/orders/1.sql". A file is selected if it matches any
+	// Include pattern and no Exclude pattern.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// Bytebase is the Bytebase settings for the changes routed by a route.
+type Bytebase struct {
+	// ProjectKey overrides the {{PROJECT_KEY}} parsed from the file path if set.
+	ProjectKey string `json:"projectKey"`
+	// UnmatchedFiles overrides the policy for the files not matching the file path template if set,
+	// one of UnmatchedFilesIgnore, UnmatchedFilesWarn and UnmatchedFilesFail.
+	UnmatchedFiles UnmatchedFilesPolicy `json:"unmatchedFiles"`
+	// FilePathTemplate overrides the default file path template if set, it must contain {{ENV_NAME}}
+	// to resolve the Bytebase instance unless Databases maps every database without it, and
+	// {{PROJECT_KEY}} unless ProjectKey is set.
+	FilePathTemplate string `json:"filePathTemplate"`
+	// SchemaFileTemplate overrides the default schema file template if set, the files matching it hold
+	// the desired schema of the database, and are applied as SDL. It must contain {{ENV_NAME}} unless
+	// Databases maps every database without it, and {{PROJECT_KEY}} unless ProjectKey is set.
+	SchemaFileTemplate string `json:"schemaFileTemplate"`
+	// VersionOrder overrides the rule for the versions older than the applied versions if set,
+	// one of VersionOrderStrict, VersionOrderOutOfOrder and VersionOrderSequential.
+	VersionOrder VersionOrder `json:"versionOrder"`
+	// VersionFormat overrides the format of the versions if set, one of VersionFormatNatural,
+	// VersionFormatSemver and VersionFormatTimestamp.
+	VersionFormat VersionFormat `json:"versionFormat"`
+	// SQLCheck overrides the policy for the SQL check before creating the issues if set, one of
+	// SQLCheckOff, SQLCheckBlock, SQLCheckAnnotate and SQLCheckReport.
+	SQLCheck SQLCheckPolicy `json:"sqlCheck"`
+	// Environments maps the {{ENV_NAME}} in the file path to the Bytebase environment ID.
+	Environments map[string]string `json:"environments"`
+	// Databases maps the {{DB_NAME}}, or "{{ENV_NAME}}/{{DB_NAME}}" for a single environment, in the file
+	// path to the database resource name, e.g. instances/{instance}/databases/{database}, or the database
+	// group resource name, e.g. projects/{project}/databaseGroups/{group}. The resource name may contain
+	// {{PROJECT_KEY}}, {{ENV_NAME}} and {{DB_NAME}} replaced by the values in the file path.
+	Databases map[string]string `json:"databases"`
+}
+
+// UnmatchedFilesPolicy is the policy for the files not matching the file path template.
+type UnmatchedFilesPolicy string
+
+const (
+	// UnmatchedFilesIgnore skips the files silently.
+	UnmatchedFilesIgnore UnmatchedFilesPolicy = "ignore"
+	// UnmatchedFilesWarn skips the files and reports them.
+	UnmatchedFilesWarn UnmatchedFilesPolicy = "warn"
+	// UnmatchedFilesFail fails the event listing the files.
+	UnmatchedFilesFail UnmatchedFilesPolicy = "fail"
+)
+
+// Validate returns an error if the policy is unknown.
+func (p UnmatchedFilesPolicy) Validate() error {
+	switch p {
+	case UnmatchedFilesIgnore, UnmatchedFilesWarn, UnmatchedFilesFail:
+		return nil
+	}
+	return fmt.Errorf("invalid unmatched files policy %q, must be ignore, warn or fail", p)
+}
+
+// VersionOrder is the rule for the versions older than the versions applied to the database.
+type VersionOrder string
+
+const (
+	// VersionOrderStrict rejects the versions not newer than every applied version.
+	VersionOrderStrict VersionOrder = "strict"
+	// VersionOrderOutOfOrder applies the older versions not applied yet, and reports them.
+	VersionOrderOutOfOrder VersionOrder = "out-of-order"
+	// VersionOrderSequential rejects the older versions like VersionOrderStrict, and the gaps between
+	// the versions, e.g. 003 after 001. It requires the natural versions of digits only.
+	VersionOrderSequential VersionOrder = "sequential"
+)
+
+// Validate returns an error if the rule is unknown.
+func (o VersionOrder) Validate() error {
+	switch o {
+	case VersionOrderStrict, VersionOrderOutOfOrder, VersionOrderSequential:
+		return nil
+	}
+	return fmt.Errorf("invalid version order %q, must be strict, out-of-order or sequential", o)
+}
+
+// VersionFormat is the format of the versions, which decides how they are validated and ordered.
+type VersionFormat string
+
+const (
+	// VersionFormatNatural orders the versions by their digit runs numerically and other runs lexically.
+	VersionFormatNatural VersionFormat = "natural"
+	// VersionFormatSemver requires the semantic versions, e.g. 1.2.0 or v1.2.0-rc.1.
+	VersionFormatSemver VersionFormat = "semver"
+	// VersionFormatTimestamp requires the UTC timestamps, e.g. 20230102150405, 202301021504 or 20230102.
+	VersionFormatTimestamp VersionFormat = "timestamp"
+)
+
+// Validate returns an error if the format is unknown.
+func (f VersionFormat) Validate() error {
+	switch f {
+	case VersionFormatNatural, VersionFormatSemver, VersionFormatTimestamp:
+		return nil
+	}
+	return fmt.Errorf("invalid version format %q, must be natural, semver or timestamp", f)
+}
+
+// SQLCheckPolicy is the policy for the Bytebase SQL check before creating the issues.
+type SQLCheckPolicy string
+
+const (
+	// SQLCheckOff creates the issues without the SQL check.
+	SQLCheckOff SQLCheckPolicy = "off"
+	// SQLCheckBlock creates no issue if the SQL check finds any error, and reports the findings.
+	SQLCheckBlock SQLCheckPolicy = "block"
+	// SQLCheckAnnotate creates the issues annotated with the findings, and reports the findings.
+	SQLCheckAnnotate SQLCheckPolicy = "annotate"
+	// SQLCheckReport creates the issues, and reports the findings.
+	SQLCheckReport SQLCheckPolicy = "report"
+)
+
+// Validate returns an error if the policy is unknown.
+func (p SQLCheckPolicy) Validate() error {
+	switch p {
+	case SQLCheckOff, SQLCheckBlock, SQLCheckAnnotate, SQLCheckReport:
+		return nil
+	}
+	return fmt.Errorf("invalid SQL check policy %q, must be off, block, annotate or report", p)
+}
+
+type matcher func(name string) bool
+
+// Load loads the route list from the JSON file.
+func Load(filePath string) ([]*Route, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var routes []*Route
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, fmt.Errorf("invalid route file %q: %w", filePath, err)
+	}
+	names := map[string]bool{}
+	for i, r := range routes {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%d", i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("invalid route file %q: duplicate route name %q", filePath, r.Name)
+		}
+		names[r.Name] = true
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", r.Name, err)
+		}
+	}
+	return routes, nil
+}
+
+// New creates a route matching exactly the project and branch.
+func New(name, project, branch string) (*Route, error) {
+	r := &Route{
+		Name:     name,
+		Projects: []string{regexPrefix + "^" + regexp.QuoteMeta(project) + "$"},
+		Branches: []string{regexPrefix + "^" + regexp.QuoteMeta(branch) + "$"},
+	}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Find returns the first route matching the project and branch, or nil if none matches.
+func Find(routes []*Route, project, branch string) *Route {
+	for _, r := range routes {
+		if r.Match(project, branch) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Match reports whether the route matches the project and branch.
+func (r *Route) Match(project, branch string) bool {
+	return matchAny(r.projects, project) && matchAny(r.branches, branch)
+}
+
+// Template returns the compiled Bytebase file path template of the route, or nil if not set.
+func (r *Route) Template() *Template {
+	return r.template
+}
+
+// SchemaTemplate returns the compiled Bytebase schema file template of the route, or nil if not set.
+func (r *Route) SchemaTemplate() *Template {
+	return r.schemaTemplate
+}
+
+// MatchFile reports whether the file path is selected by the route.
+func (r *Route) MatchFile(filePath string) bool {
+	return matchAny(r.include, filePath) && !matchAny(r.exclude, filePath)
+}
+
+func (r *Route) compile() error {
+	if len(r.Projects) == 0 {
+		return fmt.Errorf("projects is required")
+	}
+	if len(r.Branches) == 0 {
+		return fmt.Errorf("branches is required")
+	}
+	var err error
+	if r.projects, err = compilePatterns(r.Projects); err != nil {
+		return err
+	}
+	if r.branches, err = compilePatterns(r.Branches); err != nil {
+		return err
+	}
+	include := r.Files.Include
+	if len(include) == 0 {
+		include = []string{DefaultFileInclude}
+	}
+	if r.include, err = compileFilePatterns(include); err != nil {
+		return err
+	}
+	if r.exclude, err = compileFilePatterns(r.Files.Exclude); err != nil {
+		return err
+	}
+	if r.Bytebase.UnmatchedFiles != "" {
+		if err := r.Bytebase.UnmatchedFiles.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Bytebase.SQLCheck != "" {
+		if err := r.Bytebase.SQLCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Bytebase.VersionOrder != "" {
+		if err := r.Bytebase.VersionOrder.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Bytebase.VersionFormat != "" {
+		if err := r.Bytebase.VersionFormat.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := r.Bytebase.compileTargets(); err != nil {
+		return err
+	}
+	if r.Bytebase.FilePathTemplate != "" {
+		if r.template, err = CompileTemplate(r.Bytebase.FilePathTemplate); err != nil {
+			return err
+		}
+		if !r.template.Has(PlaceholderEnvironment) {
+			if err := r.Bytebase.checkTemplateWithoutEnvironment("file path", r.Bytebase.FilePathTemplate); err != nil {
+				return err
+			}
+		}
+		if !r.template.Has(PlaceholderProjectKey) && r.Bytebase.ProjectKey == "" {
+			return fmt.Errorf("bytebase.projectKey is required since the file path template %q has no {{%s}}", r.Bytebase.FilePathTemplate, PlaceholderProjectKey)
+		}
+	}
+	if r.Bytebase.SchemaFileTemplate != "" {
+		if r.schemaTemplate, err = CompileSchemaTemplate(r.Bytebase.SchemaFileTemplate); err != nil {
+			return err
+		}
+		if !r.schemaTemplate.Has(PlaceholderEnvironment) {
+			if err := r.Bytebase.checkTemplateWithoutEnvironment("schema file", r.Bytebase.SchemaFileTemplate); err != nil {
+				return err
+			}
+		}
+		if !r.schemaTemplate.Has(PlaceholderProjectKey) && r.Bytebase.ProjectKey == "" {
+			return fmt.Errorf("bytebase.projectKey is required since the schema file template %q has no {{%s}}", r.Bytebase.SchemaFileTemplate, PlaceholderProjectKey)
+		}
+	}
+	return nil
+}
+
+func compilePatterns(patterns []string) ([]matcher, error) {
+	var matchers []matcher
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, regexPrefix) {
+			re, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+			}
+			matchers = append(matchers, re.MatchString)
+			continue
+		}
+
+		// Validate the glob pattern upfront instead of silently failing every match.
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+		glob := pattern
+		matchers = append(matchers, func(name string) bool {
+			ok, _ := path.Match(glob, name)
+			return ok
+		})
+	}
+	return matchers, nil
+}
+
+// compileFilePatterns compiles the file patterns, the glob patterns are matched segment by segment
+// so that "**" matches any number of directories.
+func compileFilePatterns(patterns []string) ([]matcher, error) {
+	var matchers []matcher
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, regexPrefix) {
+			m, err := compilePatterns([]string{pattern})
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, m...)
+			continue
+		}
+
+		segments := strings.Split(pattern, "/")
+		for _, segment := range segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+			}
+		}
+		matchers = append(matchers, func(name string) bool {
+			return matchSegments(segments, strings.Split(name, "/"))
+		})
+	}
+	return matchers, nil
+}
+
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], names[0]); !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+func matchAny(matchers []matcher, name string) bool {
+	for _, m := range matchers {
+		if m(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package route
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteMatch(t *testing.T) {
+	r := &Route{
+		Projects: []string{"db/*", "re:^legacy-(orders|users)$"},
+		Branches: []string{"main", "release-*"},
+	}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		project string
+		branch  string
+		want    bool
+	}
+
+	tests := []test{
+		{
+			project: "db/orders",
+			branch:  "main",
+			want:    true,
+		},
+		{
+			project: "db/orders",
+			branch:  "release-1.2",
+			want:    true,
+		},
+		{
+			project: "db/orders/archive",
+			branch:  "main",
+			want:    false,
+		},
+		{
+			project: "db/orders",
+			branch:  "feature",
+			want:    false,
+		},
+		{
+			project: "legacy-users",
+			branch:  "main",
+			want:    true,
+		},
+		{
+			project: "legacy-users-v2",
+			branch:  "main",
+			want:    false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.project+"@"+tc.branch, func(t *testing.T) {
+			if got := r.Match(tc.project, tc.branch); got != tc.want {
+				t.Errorf("Expect %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewMatchesExactly(t *testing.T) {
+	r, err := New("default", "db.orders", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Match("db.orders", "main") {
+		t.Error("Expect to match the exact project and branch")
+	}
+	if r.Match("db-orders", "main") || r.Match("db.orders", "main2") {
+		t.Error("Expect not to match other projects or branches")
+	}
+}
+
+func TestRouteMatchFile(t *testing.T) {
+	r := &Route{
+		Projects: []string{"db"},
+		Branches: []string{"main"},
+		Files: Files{
+			Include: []string{"migrations/**/*.up.sql"},
+			Exclude: []string{"**/*_test.up.sql"},
+		},
+	}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	defaultRoute, err := New("default", "db", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		route    *Route
+		filePath string
+		want     bool
+	}{
+		{route: r, filePath: "migrations/1.up.sql", want: true},
+		{route: r, filePath: "migrations/orders/prod/1.up.sql", want: true},
+		{route: r, filePath: "migrations/1.down.sql", want: false},
+		{route: r, filePath: "migrations/orders/1_test.up.sql", want: false},
+		{route: r, filePath: "docs/migrations/1.up.sql", want: false},
+		{route: defaultRoute, filePath: "1.sql", want: true},
+		{route: defaultRoute, filePath: "orders/prod/1.sql", want: true},
+		{route: defaultRoute, filePath: "orders/prod/1.sql.txt", want: false},
+		{route: defaultRoute, filePath: "/COMMIT_MSG", want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.route.Name+":"+tc.filePath, func(t *testing.T) {
+			if got := tc.route.MatchFile(tc.filePath); got != tc.want {
+				t.Errorf("Expect %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	for _, r := range []*Route{
+		{Projects: []string{"re:("}, Branches: []string{"main"}},
+		{Projects: []string{"db/["}, Branches: []string{"main"}},
+		{Projects: []string{"db/*"}},
+		{Projects: []string{"db"}, Branches: []string{"main"}, Files: Files{Exclude: []string{"**/["}}},
+		{Projects: []string{"db"}, Branches: []string{"main"}, Bytebase: Bytebase{FilePathTemplate: "{{DB_NAME}}##{{VERSION}}##{{TYPE}}.sql"}},
+	} {
+		if err := r.compile(); err == nil {
+			t.Errorf("Expect error for %+v", r)
+		}
+	}
+}
+
+func TestLoadDuplicateName(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "routes.json")
+	content := `[
+		{"name": "orders", "projects": ["db/orders"], "branches": ["main"]},
+		{"name": "orders", "projects": ["db/orders"], "branches": ["release-*"]}
+	]`
+	if err := os.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(filePath); err == nil {
+		t.Fatal("Expect error for the duplicate route names")
+	}
+}
+
+func TestRouteDatabaseTarget(t *testing.T) {
+	r := &Route{
+		Projects: []string{"db"},
+		Branches: []string{"main"},
+		Bytebase: Bytebase{
+			Environments: map[string]string{"production": "prod"},
+			Databases: map[string]string{
+				"orders":      "instances/{{ENV_NAME}}-mysql/databases/orders_db",
+				"test/orders": "instances/sandbox/databases/orders",
+				"tenants":     "projects/{{PROJECT_KEY}}/databaseGroups/tenants",
+			},
+		},
+	}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Environment("production"); got != "prod" {
+		t.Errorf("Expect the mapped environment, got %q", got)
+	}
+	if got := r.Environment("staging"); got != "staging" {
+		t.Errorf("Expect the environment not mapped, got %q", got)
+	}
+
+	tests := []struct {
+		environment string
+		database    string
+		want        string
+	}{
+		{environment: "production", database: "orders", want: "instances/production-mysql/databases/orders_db"},
+		{environment: "test", database: "orders", want: "instances/sandbox/databases/orders"},
+		{environment: "production", database: "tenants", want: "projects/db/databaseGroups/tenants"},
+		{environment: "production", database: "users", want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.environment+"/"+tc.database, func(t *testing.T) {
+			got, ok := r.DatabaseTarget("db", tc.environment, tc.database)
+			if ok != (tc.want != "") || got != tc.want {
+				t.Errorf("Expect %q, got %q", tc.want, got)
+			}
+		})
+	}
+	if !IsDatabaseGroup("projects/db/databaseGroups/tenants") || IsDatabaseGroup("instances/prod/databases/orders") {
+		t.Error("Expect only the database group resource name to be a database group")
+	}
+}
+
+func TestInvalidDatabaseTarget(t *testing.T) {
+	for _, bytebase := range []Bytebase{
+		{Environments: map[string]string{"production": ""}},
+		{Environments: map[string]string{"production": "environments/prod"}},
+		{Databases: map[string]string{"orders": "orders"}},
+		{Databases: map[string]string{"orders": "instances/prod/databases/orders/tables/t"}},
+		{Databases: map[string]string{"orders": "instances/{{VERSION}}/databases/orders"}},
+		{Databases: map[string]string{"": "instances/prod/databases/orders"}},
+		// The file path template without {{ENV_NAME}} needs the databases mapped without it.
+		{FilePathTemplate: "{{PROJECT_KEY}}/{{DB_NAME}}##{{VERSION}}.sql"},
+		{FilePathTemplate: "{{PROJECT_KEY}}/{{DB_NAME}}##{{VERSION}}.sql", Databases: map[string]string{"prod/orders": "instances/prod/databases/orders"}},
+		{SchemaFileTemplate: "{{PROJECT_KEY}}/{{DB_NAME}}.sql", Databases: map[string]string{"orders": "instances/{{ENV_NAME}}/databases/orders"}},
+	} {
+		r := &Route{Projects: []string{"db"}, Branches: []string{"main"}, Bytebase: bytebase}
+		if err := r.compile(); err == nil {
+			t.Errorf("Expect error for %+v", bytebase)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// targetPlaceholderList is the placeholders in the database targets, replaced by the values in the file path.
+	targetPlaceholderList = []string{
+		PlaceholderProjectKey,
+		PlaceholderEnvironment,
+		PlaceholderDatabase,
+	}
+	databaseTargetRegexp      = regexp.MustCompile(`^instances/[^/]+/databases/[^/]+$`)
+	databaseGroupTargetRegexp = regexp.MustCompile(`^projects/[^/]+/databaseGroups/[^/]+$`)
+	targetPlaceholderRegexp   = regexp.MustCompile(`\{\{([A-Za-z_]+)\}\}`)
+)
+
+// IsDatabaseGroup reports whether the target is a database group, e.g. projects/{project}/databaseGroups/{group}.
+func IsDatabaseGroup(target string) bool {
+	return databaseGroupTargetRegexp.MatchString(target)
+}
+
+// Environment returns the Bytebase environment ID of the {{ENV_NAME}} in the file path, which is
+// the same unless mapped by the route.
+func (r *Route) Environment(environment string) string {
+	if id, ok := r.Bytebase.Environments[environment]; ok {
+		return id
+	}
+	return environment
+}
+
+// DatabaseTarget returns the database or the database group resource name mapped by the route from
+// the {{ENV_NAME}} and the {{DB_NAME}} in the file path, or false if not mapped. The mapping of
+// "{{ENV_NAME}}/{{DB_NAME}}" takes precedence over the mapping of "{{DB_NAME}}".
+func (r *Route) DatabaseTarget(project, environment, database string) (string, bool) {
+	target, ok := r.Bytebase.Databases[environment+"/"+database]
+	if !ok {
+		if target, ok = r.Bytebase.Databases[database]; !ok {
+			return "", false
+		}
+	}
+	values := map[string]string{
+		PlaceholderProjectKey:  project,
+		PlaceholderEnvironment: environment,
+		PlaceholderDatabase:    database,
+	}
+	return targetPlaceholderRegexp.ReplaceAllStringFunc(target, func(placeholder string) string {
+		return values[strings.Trim(placeholder, "{}")]
+	}), true
+}
+
+// compileTargets validates the environment and the database mappings.
+func (b *Bytebase) compileTargets() error {
+	for environment, id := range b.Environments {
+		if environment == "" || id == "" || strings.Contains(id, "/") {
+			return fmt.Errorf("invalid bytebase.environments mapping %q to %q, the environment ID must be non-empty without \"/\"", environment, id)
+		}
+	}
+	for database, target := range b.Databases {
+		if database == "" {
+			return fmt.Errorf("invalid bytebase.databases mapping to %q, the database name must be non-empty", target)
+		}
+		for _, m := range targetPlaceholderRegexp.FindAllStringSubmatch(target, -1) {
+			if !isTargetPlaceholder(m[1]) {
+				return fmt.Errorf("invalid bytebase.databases mapping %q to %q, unknown placeholder {{%s}}, supported placeholders are {{%s}}", database, target, m[1], strings.Join(targetPlaceholderList, "}}, {{"))
+			}
+		}
+		// The placeholder values never contain "/", so the placeholders are checked as a segment.
+		shape := targetPlaceholderRegexp.ReplaceAllString(target, "x")
+		if !databaseTargetRegexp.MatchString(shape) && !databaseGroupTargetRegexp.MatchString(shape) {
+			return fmt.Errorf("invalid bytebase.databases mapping %q to %q, must be instances/{instance}/databases/{database} or projects/{project}/databaseGroups/{group}", database, target)
+		}
+	}
+	return nil
+}
+
+// checkTemplateWithoutEnvironment returns an error unless the databases are mapped without the
+// {{ENV_NAME}}, which resolve the Bytebase instances of the files matching the template without it.
+func (b *Bytebase) checkTemplateWithoutEnvironment(kind, template string) error {
+	if len(b.Databases) == 0 {
+		return fmt.Errorf("the %s template %q has no {{%s}} to resolve the Bytebase instance, and bytebase.databases is not set", kind, template, PlaceholderEnvironment)
+	}
+	for database, target := range b.Databases {
+		if strings.Contains(database, "/") || strings.Contains(target, "{{"+PlaceholderEnvironment+"}}") {
+			return fmt.Errorf("the %s template %q has no {{%s}}, but the bytebase.databases mapping %q to %q depends on it", kind, template, PlaceholderEnvironment, database, target)
+		}
+	}
+	return nil
+}
+
+func isTargetPlaceholder(name string) bool {
+	for _, placeholder := range targetPlaceholderList {
+		if placeholder == name {
+			return true
+		}
+	}
+	return false
+}
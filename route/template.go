@@ -0,0 +1,184 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultFilePathTemplate is the file path template used if neither the route nor the flag sets one.
+const DefaultFilePathTemplate = "{{PROJECT_KEY}}/{{ENV_NAME}}/{{DB_NAME}}##{{VERSION}}##{{TYPE}}##{{DESCRIPTION}}.sql"
+
+// The placeholders in the file path template.
+const (
+	PlaceholderProjectKey  = "PROJECT_KEY"
+	PlaceholderEnvironment = "ENV_NAME"
+	PlaceholderDatabase    = "DB_NAME"
+	PlaceholderVersion     = "VERSION"
+	PlaceholderType        = "TYPE"
+	PlaceholderDescription = "DESCRIPTION"
+)
+
+var (
+	placeholderList = []string{
+		PlaceholderProjectKey,
+		PlaceholderEnvironment,
+		PlaceholderDatabase,
+		PlaceholderVersion,
+		PlaceholderType,
+		PlaceholderDescription,
+	}
+	// requiredPlaceholderList is the placeholders every migration template must have outside the optional sections.
+	requiredPlaceholderList = []string{
+		PlaceholderDatabase,
+		PlaceholderVersion,
+	}
+	// placeholderValueRegexp matches a placeholder value, it is lazy so that the value stops at the
+	// first occurrence of the separator following the placeholder.
+	placeholderValueRegexp = `[^\\/?%*:|"<>]+?`
+	placeholderTokenRegexp = regexp.MustCompile(`^\{\{([A-Za-z_]+)\}\}`)
+)
+
+// Template is a compiled file path template. Besides the placeholders like {{VERSION}}, the template
+// may contain "*" matching any characters but "/", "**" matching any characters, and the optional
+// sections enclosed in "[" and "]", e.g. "{{DB_NAME}}/{{VERSION}}[__{{DESCRIPTION}}].sql". The other
+// characters are literals, so any separator can be used between the placeholders.
+type Template struct {
+	raw          string
+	re           *regexp.Regexp
+	placeholders map[string]bool
+}
+
+// CompileTemplate compiles and validates the file path template of the versioned migration files.
+func CompileTemplate(template string) (*Template, error) {
+	return compileTemplate(template, requiredPlaceholderList)
+}
+
+// CompileSchemaTemplate compiles and validates the file path template of the schema files, which hold the
+// desired state of the database schema. The template requires {{DB_NAME}}, and cannot contain {{TYPE}}.
+func CompileSchemaTemplate(template string) (*Template, error) {
+	t, err := compileTemplate(template, []string{PlaceholderDatabase})
+	if err != nil {
+		return nil, err
+	}
+	if t.Has(PlaceholderType) {
+		return nil, fmt.Errorf("invalid schema file template %q: {{%s}} is not allowed, the schema files are always applied as SDL", template, PlaceholderType)
+	}
+	return t, nil
+}
+
+func compileTemplate(template string, requiredPlaceholderList []string) (*Template, error) {
+	t := &Template{
+		raw:          template,
+		placeholders: map[string]bool{},
+	}
+	required := map[string]bool{}
+	var sb strings.Builder
+	// The template matches the file path or its suffix following a "/", e.g. in a sub-directory.
+	sb.WriteString(`^(?:.*?/)?`)
+	optional := false
+	for rest := template; rest != ""; {
+		if m := placeholderTokenRegexp.FindStringSubmatch(rest); m != nil {
+			placeholder := m[1]
+			if !isPlaceholder(placeholder) {
+				return nil, fmt.Errorf("invalid file path template %q: unknown placeholder {{%s}}, supported placeholders are %s", template, placeholder, placeholderNames())
+			}
+			if t.placeholders[placeholder] {
+				return nil, fmt.Errorf("invalid file path template %q: duplicate placeholder {{%s}}", template, placeholder)
+			}
+			t.placeholders[placeholder] = true
+			if !optional {
+				required[placeholder] = true
+			}
+			fmt.Fprintf(&sb, `(?P<%s>%s)`, placeholder, placeholderValueRegexp)
+			rest = rest[len(m[0]):]
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "**"):
+			sb.WriteString(`.*`)
+			rest = rest[2:]
+		case rest[0] == '*':
+			sb.WriteString(`[^/]*`)
+			rest = rest[1:]
+		case rest[0] == '[':
+			if optional {
+				return nil, fmt.Errorf("invalid file path template %q: nested optional sections", template)
+			}
+			optional = true
+			sb.WriteString(`(?:`)
+			rest = rest[1:]
+		case rest[0] == ']':
+			if !optional {
+				return nil, fmt.Errorf("invalid file path template %q: unbalanced \"]\"", template)
+			}
+			optional = false
+			sb.WriteString(`)?`)
+			rest = rest[1:]
+		default:
+			sb.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	if optional {
+		return nil, fmt.Errorf("invalid file path template %q: unbalanced \"[\"", template)
+	}
+	sb.WriteString(`$`)
+
+	for _, placeholder := range requiredPlaceholderList {
+		if !required[placeholder] {
+			return nil, fmt.Errorf("invalid file path template %q: {{%s}} is required outside the optional sections", template, placeholder)
+		}
+	}
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path template %q: %w", template, err)
+	}
+	t.re = re
+	return t, nil
+}
+
+// String returns the template.
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Has reports whether the template contains the placeholder.
+func (t *Template) Has(placeholder string) bool {
+	return t.placeholders[placeholder]
+}
+
+// Match matches the file path against the template, and returns the values of the placeholders.
+// The placeholders in the optional sections absent from the file path are omitted.
+func (t *Template) Match(filePath string) (map[string]string, bool) {
+	matchList := t.re.FindStringSubmatch(filePath)
+	if matchList == nil {
+		return nil, false
+	}
+	values := map[string]string{}
+	for _, placeholder := range placeholderList {
+		if index := t.re.SubexpIndex(placeholder); index >= 0 && matchList[index] != "" {
+			values[placeholder] = matchList[index]
+		}
+	}
+	return values, true
+}
+
+func isPlaceholder(name string) bool {
+	for _, placeholder := range placeholderList {
+		if placeholder == name {
+			return true
+		}
+	}
+	return false
+}
+
+func placeholderNames() string {
+	var names []string
+	for _, placeholder := range placeholderList {
+		names = append(names, fmt.Sprintf("{{%s}}", placeholder))
+	}
+	return strings.Join(names, ", ")
+}
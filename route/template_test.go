@@ -0,0 +1,130 @@
+package route
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTemplateMatch(t *testing.T) {
+	type test struct {
+		template string
+		filePath string
+		want     map[string]string
+	}
+
+	tests := []test{
+		{
+			template: DefaultFilePathTemplate,
+			filePath: "db/prod/orders##001##ddl##create_table.sql",
+			want:     map[string]string{"PROJECT_KEY": "db", "ENV_NAME": "prod", "DB_NAME": "orders", "VERSION": "001", "TYPE": "ddl", "DESCRIPTION": "create_table"},
+		},
+		{
+			// The template matches in a sub-directory.
+			template: DefaultFilePathTemplate,
+			filePath: "migrations/db/prod/orders##001##ddl##create_table.sql",
+			want:     map[string]string{"PROJECT_KEY": "db", "ENV_NAME": "prod", "DB_NAME": "orders", "VERSION": "001", "TYPE": "ddl", "DESCRIPTION": "create_table"},
+		},
+		{
+			template: DefaultFilePathTemplate,
+			filePath: "db/prod/orders##001##ddl##create_table.sql.bak",
+			want:     nil,
+		},
+		{
+			template: "{{DB_NAME}}__{{VERSION}}__{{DESCRIPTION}}.sql",
+			filePath: "orders__20230101__add__index.sql",
+			want:     map[string]string{"DB_NAME": "orders", "VERSION": "20230101", "DESCRIPTION": "add__index"},
+		},
+		{
+			template: "**/{{DB_NAME}}/V{{VERSION}}[__{{DESCRIPTION}}].sql",
+			filePath: "sql/prod/orders/V1.2.sql",
+			want:     map[string]string{"DB_NAME": "orders", "VERSION": "1.2"},
+		},
+		{
+			template: "**/{{DB_NAME}}/V{{VERSION}}[__{{DESCRIPTION}}].sql",
+			filePath: "sql/prod/orders/V1.2__add_index.sql",
+			want:     map[string]string{"DB_NAME": "orders", "VERSION": "1.2", "DESCRIPTION": "add_index"},
+		},
+		{
+			template: "{{ENV_NAME}}/*/{{DB_NAME}}.{{VERSION}}.sql",
+			filePath: "prod/shard-1/orders.3.sql",
+			want:     map[string]string{"ENV_NAME": "prod", "DB_NAME": "orders", "VERSION": "3"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.filePath, func(t *testing.T) {
+			template, err := CompileTemplate(tc.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := template.Match(tc.filePath)
+			if ok != (tc.want != nil) {
+				t.Fatalf("Expect match %v, got %v", tc.want != nil, ok)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) && tc.want != nil {
+				t.Errorf("Expect %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	for _, template := range []string{
+		"{{DB_NAME}}.sql",
+		"{{VERSION}}.sql",
+		"{{DB_NAME}}[##{{VERSION}}].sql",
+		"{{DB_NAME}}##{{VERSION}}##{{FOO}}.sql",
+		"{{DB_NAME}}##{{VERSION}}##{{DB_NAME}}.sql",
+		"{{DB_NAME}}##{{VERSION}}[##[{{TYPE}}]].sql",
+		"{{DB_NAME}}##{{VERSION}}[##{{TYPE}}.sql",
+		"{{DB_NAME}}##{{VERSION}}##{{TYPE}}].sql",
+	} {
+		if _, err := CompileTemplate(template); err == nil {
+			t.Errorf("Expect error for %q", template)
+		}
+	}
+}
+
+func TestSchemaTemplate(t *testing.T) {
+	template, err := CompileSchemaTemplate("{{PROJECT_KEY}}/schema/{{DB_NAME}}.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := template.Match("db/schema/orders.sql")
+	if want := map[string]string{"PROJECT_KEY": "db", "DB_NAME": "orders"}; !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expect %v, got %v", want, got)
+	}
+
+	for _, template := range []string{
+		"schema/{{ENV_NAME}}.sql",
+		"schema/{{DB_NAME}}##{{TYPE}}.sql",
+	} {
+		if _, err := CompileSchemaTemplate(template); err == nil {
+			t.Errorf("Expect error for %q", template)
+		}
+	}
+}
+
+func TestRouteTemplateRequiresProjectKey(t *testing.T) {
+	r := &Route{
+		Projects: []string{"db"},
+		Branches: []string{"main"},
+		Bytebase: Bytebase{FilePathTemplate: "{{ENV_NAME}}/{{DB_NAME}}##{{VERSION}}.sql"},
+	}
+	if err := r.compile(); err == nil {
+		t.Error("Expect error without the project key")
+	}
+	r.Bytebase.ProjectKey = "db"
+	if err := r.compile(); err != nil {
+		t.Error(err)
+	}
+
+	r = &Route{
+		Projects: []string{"db"},
+		Branches: []string{"main"},
+		Bytebase: Bytebase{SchemaFileTemplate: "schema/{{DB_NAME}}.sql"},
+	}
+	if err := r.compile(); err == nil {
+		t.Error("Expect error without the project key for the schema file template")
+	}
+}
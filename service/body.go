@@ -0,0 +1,27 @@
+package service
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFileTooLarge is returned if the file content exceeds the max file size, the content is not read whole.
+var ErrFileTooLarge = errors.New("file too large")
+
+// readBody reads the body, at most limit bytes if limit is positive. tooLarge reports whether the
+// body is longer than limit, the body read is truncated then.
+func readBody(body io.Reader, limit int64) (b []byte, tooLarge bool, err error) {
+	if limit <= 0 {
+		b, err = io.ReadAll(body)
+		return b, false, err
+	}
+	b, err = io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(b)) > limit {
+		return b[:limit], true, nil
+	}
+	return b, false, nil
+}
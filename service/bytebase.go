@@ -2,11 +2,17 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bytebase/relay/payload"
 	"github.com/pkg/errors"
@@ -16,6 +22,65 @@ type BytebaseService struct {
 	url    string
 	key    string
 	secret string
+
+	// mu guards the cached token.
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+
+	// apiMu guards the API version, which is detected on the first issue creation if auto.
+	apiMu      sync.Mutex
+	apiVersion BytebaseAPIVersion
+}
+
+// BytebaseAPIVersion is the API used to create the issues.
+type BytebaseAPIVersion string
+
+const (
+	// BytebaseAPIAuto detects the API version by the server version.
+	BytebaseAPIAuto BytebaseAPIVersion = "auto"
+	// BytebaseAPIV1 creates the sheets, the plan and the issue referencing the plan.
+	BytebaseAPIV1 BytebaseAPIVersion = "v1"
+	// BytebaseAPILegacy creates the issue with the statement directly, for the servers before 2.0.
+	BytebaseAPILegacy BytebaseAPIVersion = "legacy"
+)
+
+// bytebaseMinV1Major is the first major server version accepting the plans.
+const bytebaseMinV1Major = 2
+
+// bytebasePageSize is the page size listing the resources.
+const bytebasePageSize = 100
+
+const (
+	// bytebaseTokenRefreshMargin is how long before the expiry the token is refreshed.
+	bytebaseTokenRefreshMargin = time.Minute
+	// bytebaseTokenDefaultTTL is the lifetime assumed for the token without the exp claim.
+	bytebaseTokenDefaultTTL = 30 * time.Minute
+)
+
+// BytebaseError is the error of a non-200 response from the Bytebase API.
+type BytebaseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *BytebaseError) Error() string {
+	return fmt.Sprintf("status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// BytebaseLoginError is the error of logging in with the service account, to tell the invalid credentials
+// from the failures of the API requests.
+type BytebaseLoginError struct {
+	Account string
+	Err     error
+}
+
+func (e *BytebaseLoginError) Error() string {
+	return fmt.Sprintf("failed to login Bytebase as %s: %v", e.Account, e.Err)
+}
+
+func (e *BytebaseLoginError) Unwrap() error {
+	return e.Err
 }
 
 type bytebaseAuthRequest struct {
@@ -27,73 +92,525 @@ type bytebaseAuthResponse struct {
 	Token string `json:"token"`
 }
 
-// NewLark creates a Bytebase service
-func NewBytebase(url, key, secret string) *BytebaseService {
+// NewBytebase creates a Bytebase service using the API version, BytebaseAPIAuto to detect it.
+func NewBytebase(url, key, secret string, apiVersion BytebaseAPIVersion) *BytebaseService {
 	return &BytebaseService{
-		url:    url,
-		key:    key,
-		secret: secret,
+		url:        url,
+		key:        key,
+		secret:     secret,
+		apiVersion: apiVersion,
 	}
 }
 
-// CreateIssue creates a single issue in a project.
-func (s *BytebaseService) CreateIssue(ctx context.Context, create *payload.IssueCreate) error {
-	payload, err := json.Marshal(create)
+// APIVersion returns the API used to create the issues, detected by the server version if auto.
+func (s *BytebaseService) APIVersion(ctx context.Context) (BytebaseAPIVersion, error) {
+	return s.detectAPIVersion(ctx)
+}
+
+// CreateIssues creates the issue applying the changes of the steps in order. With the v1 API, a single
+// issue is created, and the sheets created are deleted if it fails. With the legacy API, an issue is
+// created for every change in order, and the issues created are returned together with the error.
+func (s *BytebaseService) CreateIssues(ctx context.Context, create *payload.MultiStepIssueCreate) ([]*payload.Issue, error) {
+	apiVersion, err := s.detectAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if apiVersion == BytebaseAPILegacy {
+		var issueList []*payload.Issue
+		for _, step := range create.Steps {
+			for _, change := range step.Changes {
+				issue, err := s.createLegacyIssue(ctx, change)
+				if err != nil {
+					return issueList, err
+				}
+				issueList = append(issueList, issue)
+			}
+		}
+		return issueList, nil
+	}
+
+	issue, err := s.createPlannedIssue(ctx, create)
+	if err != nil {
+		return nil, err
+	}
+	return []*payload.Issue{issue}, nil
+}
+
+// detectAPIVersion returns the configured API version, or detects it by the server version once.
+// The servers without the actuator API or before 2.0 use the legacy API.
+func (s *BytebaseService) detectAPIVersion(ctx context.Context) (BytebaseAPIVersion, error) {
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+	if s.apiVersion != BytebaseAPIAuto && s.apiVersion != "" {
+		return s.apiVersion, nil
+	}
+
+	info, err := s.GetActuatorInfo(ctx)
+	var bytebaseErr *BytebaseError
+	switch {
+	case errors.As(err, &bytebaseErr) && bytebaseErr.StatusCode == http.StatusNotFound:
+		s.apiVersion = BytebaseAPILegacy
+	case err != nil:
+		return "", errors.Wrap(err, "failed to detect the Bytebase API version")
+	case serverMajorVersion(info.Version) >= bytebaseMinV1Major:
+		s.apiVersion = BytebaseAPIV1
+	default:
+		s.apiVersion = BytebaseAPILegacy
+	}
+	return s.apiVersion, nil
+}
+
+// serverMajorVersion returns the major version of the server version like "2.10.0" or "v2.10.0", or 0 if invalid.
+func serverMajorVersion(version string) int {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// GetActuatorInfo returns the server information including the version.
+func (s *BytebaseService) GetActuatorInfo(ctx context.Context) (*payload.ActuatorInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v1/actuator/info", s.url), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &payload.ActuatorInfo{}
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// createPlannedIssue creates a sheet for each statement, the plan applying the sheets step by step,
+// and the issue referencing the plan. The sheets are deleted if any creation fails, the plan is left
+// since it cannot be deleted, and is invisible without the issue.
+func (s *BytebaseService) createPlannedIssue(ctx context.Context, create *payload.MultiStepIssueCreate) (issue *payload.Issue, err error) {
+	project := fmt.Sprintf("projects/%s", create.ProjectKey)
+	var sheetList []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, sheet := range sheetList {
+			if deleteErr := s.DeleteSheet(ctx, sheet); deleteErr != nil {
+				fmt.Printf("Failed to delete the sheet %s: %v\n", sheet, deleteErr)
+			}
+		}
+	}()
+
+	var stepList []*payload.PlanStep
+	for _, createStep := range create.Steps {
+		step := &payload.PlanStep{Title: createStep.Title}
+		for _, change := range createStep.Changes {
+			sheet, err := s.CreateSheet(ctx, project, &payload.Sheet{
+				Title:   change.Name,
+				Content: []byte(change.Statement),
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create the sheet of %q", change.Name)
+			}
+			sheetList = append(sheetList, sheet.Name)
+			id, err := newUUID()
+			if err != nil {
+				return nil, err
+			}
+			step.Specs = append(step.Specs, &payload.PlanSpec{
+				ID: id,
+				ChangeDatabaseConfig: &payload.ChangeDatabaseConfig{
+					Target:        change.Target,
+					Sheet:         sheet.Name,
+					Type:          change.MigrationType,
+					SchemaVersion: change.SchemaVersion,
+				},
+			})
+		}
+		stepList = append(stepList, step)
+	}
+
+	plan, err := s.CreatePlan(ctx, project, &payload.Plan{
+		Title:       create.Title,
+		Description: create.Description,
+		Steps:       stepList,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the plan")
+	}
+
+	return s.createIssue(ctx, project, &payload.Issue{
+		Title:       create.Title,
+		Description: create.Description,
+		Type:        payload.IssueTypeDatabaseChange,
+		Plan:        plan.Name,
+	})
+}
+
+// CreateSheet creates the sheet in the project, e.g. projects/{project}.
+func (s *BytebaseService) CreateSheet(ctx context.Context, project string, sheet *payload.Sheet) (*payload.Sheet, error) {
+	created := &payload.Sheet{}
+	if err := s.postJSON(ctx, fmt.Sprintf("%s/v1/%s/sheets", s.url, project), sheet, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// CreatePlan creates the plan in the project, e.g. projects/{project}.
+func (s *BytebaseService) CreatePlan(ctx context.Context, project string, plan *payload.Plan) (*payload.Plan, error) {
+	created := &payload.Plan{}
+	if err := s.postJSON(ctx, fmt.Sprintf("%s/v1/%s/plans", s.url, project), plan, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// DeleteSheet deletes the sheet, e.g. projects/{project}/sheets/{sheet}.
+func (s *BytebaseService) DeleteSheet(ctx context.Context, sheet string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/v1/%s", s.url, sheet), nil)
 	if err != nil {
 		return err
 	}
+	_, err = s.doRequest(req)
+	return err
+}
+
+// GetSheetContent returns the statement of the sheet, e.g. projects/{project}/sheets/{sheet}.
+func (s *BytebaseService) GetSheetContent(ctx context.Context, sheet string) (string, error) {
+	res := &payload.Sheet{}
+	if err := s.getJSON(ctx, fmt.Sprintf("%s/v1/%s?raw=true", s.url, sheet), res); err != nil {
+		return "", err
+	}
+	return string(res.Content), nil
+}
+
+// GetIssue returns the issue, e.g. projects/{project}/issues/{issue}.
+func (s *BytebaseService) GetIssue(ctx context.Context, issue string) (*payload.Issue, error) {
+	res := &payload.Issue{}
+	if err := s.getJSON(ctx, fmt.Sprintf("%s/v1/%s", s.url, issue), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListPlans returns all plans in the project, e.g. projects/{project}.
+func (s *BytebaseService) ListPlans(ctx context.Context, project string) ([]*payload.Plan, error) {
+	var planList []*payload.Plan
+	for pageToken := ""; ; {
+		res := &payload.ListPlansResponse{}
+		if err := s.getJSON(ctx, fmt.Sprintf("%s/v1/%s/plans?%s", s.url, project, pageQuery(pageToken)), res); err != nil {
+			return nil, err
+		}
+		planList = append(planList, res.Plans...)
+		if pageToken = res.NextPageToken; pageToken == "" {
+			return planList, nil
+		}
+	}
+}
+
+// ListChangeHistories returns all change histories of the database with the statements,
+// e.g. instances/{instance}/databases/{database}.
+func (s *BytebaseService) ListChangeHistories(ctx context.Context, database string) ([]*payload.ChangeHistory, error) {
+	var historyList []*payload.ChangeHistory
+	for pageToken := ""; ; {
+		res := &payload.ListChangeHistoriesResponse{}
+		if err := s.getJSON(ctx, fmt.Sprintf("%s/v1/%s/changeHistories?view=CHANGE_HISTORY_VIEW_FULL&%s", s.url, database, pageQuery(pageToken)), res); err != nil {
+			return nil, err
+		}
+		historyList = append(historyList, res.ChangeHistories...)
+		if pageToken = res.NextPageToken; pageToken == "" {
+			return historyList, nil
+		}
+	}
+}
+
+// pageQuery returns the query parameters to list the page.
+func pageQuery(pageToken string) string {
+	query := url.Values{}
+	query.Set("page_size", strconv.Itoa(bytebasePageSize))
+	if pageToken != "" {
+		query.Set("page_token", pageToken)
+	}
+	return query.Encode()
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/issues", s.url), strings.NewReader(string(payload)))
+// CancelIssues cancels the issues created with the v1 API, e.g. projects/{project}/issues/{issue}.
+func (s *BytebaseService) CancelIssues(ctx context.Context, issueList []string, reason string) error {
+	return s.postJSON(ctx, fmt.Sprintf("%s/v1/projects/-/issues:batchUpdateStatus", s.url), &payload.IssueStatusUpdate{
+		Issues: issueList,
+		Status: payload.IssueStatusCanceled,
+		Reason: reason,
+	}, &struct{}{})
+}
+
+func (s *BytebaseService) createIssue(ctx context.Context, project string, issue *payload.Issue) (*payload.Issue, error) {
+	created := &payload.Issue{}
+	if err := s.postJSON(ctx, fmt.Sprintf("%s/v1/%s/issues", s.url, project), issue, created); err != nil {
+		return nil, errors.Wrap(err, "failed to create the issue")
+	}
+	return created, nil
+}
+
+// getJSON gets the url and decodes the JSON response into v.
+func (s *BytebaseService) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
-	if _, err := s.doRequest(req); err != nil {
+	body, err := s.doRequest(req)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return json.Unmarshal(body, v)
 }
 
-func (s *BytebaseService) login() (*bytebaseAuthResponse, error) {
-	rb, err := json.Marshal(&bytebaseAuthRequest{
-		Email:    s.key,
-		Password: s.secret,
-	})
+// postJSON posts the JSON encoded request to the url and decodes the JSON response into v.
+func (s *BytebaseService) postJSON(ctx context.Context, url string, request, v interface{}) error {
+	rb, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(rb)))
+	if err != nil {
+		return err
+	}
+
+	body, err := s.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// createLegacyIssue creates the issue with the statement directly, for the servers before 2.0.
+func (s *BytebaseService) createLegacyIssue(ctx context.Context, create *payload.IssueCreate) (*payload.Issue, error) {
+	if strings.HasPrefix(create.Target, "projects/") {
+		return nil, errors.Errorf("%q targets the database group %s, which requires the v1 API", create.Name, create.Target)
+	}
+	rb, err := json.Marshal(create)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/auth/login", s.url), strings.NewReader(string(rb)))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/issues", s.url), strings.NewReader(string(rb)))
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := s.doRequestWithToken(req, "")
+	body, err := s.doRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
-	res := &bytebaseAuthResponse{}
-	err = json.Unmarshal(body, &res)
+	issue := &payload.Issue{}
+	if err := json.Unmarshal(body, issue); err != nil {
+		return nil, err
+	}
+
+	return issue, nil
+}
+
+// CreateRollout creates the rollout of the plan to apply the changes.
+func (s *BytebaseService) CreateRollout(ctx context.Context, plan string) (*payload.Rollout, error) {
+	// The plan name is in the form of projects/{project}/plans/{plan}.
+	project, _, ok := strings.Cut(plan, "/plans/")
+	if !ok {
+		return nil, errors.Errorf("invalid plan name %q", plan)
+	}
+	rb, err := json.Marshal(&payload.RolloutCreate{Plan: plan})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/%s/rollouts", s.url, project), strings.NewReader(string(rb)))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.doRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
+	rollout := &payload.Rollout{}
+	if err := json.Unmarshal(body, rollout); err != nil {
+		return nil, err
+	}
+
+	return rollout, nil
+}
+
+// GetRollout returns the rollout with the stages and tasks, e.g. projects/{project}/rollouts/{rollout}.
+func (s *BytebaseService) GetRollout(ctx context.Context, rollout string) (*payload.Rollout, error) {
+	res := &payload.Rollout{}
+	if err := s.getJSON(ctx, fmt.Sprintf("%s/v1/%s", s.url, rollout), res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
+// BatchRunTasks runs the tasks of the stage again, e.g. the failed tasks,
+// the stage is in the form of projects/{project}/rollouts/{rollout}/stages/{stage}.
+func (s *BytebaseService) BatchRunTasks(ctx context.Context, stage string, taskList []string, reason string) error {
+	return s.postJSON(ctx, fmt.Sprintf("%s/v1/%s/tasks:batchRun", s.url, stage), &payload.BatchRunTasksRequest{
+		Tasks:  taskList,
+		Reason: reason,
+	}, &struct{}{})
+}
+
+// CheckSQL checks the statement against the SQL review policy of the database.
+func (s *BytebaseService) CheckSQL(ctx context.Context, check *payload.SQLCheckRequest) ([]*payload.SQLAdvice, error) {
+	rb, err := json.Marshal(check)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/sql/check", s.url), strings.NewReader(string(rb)))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &payload.SQLCheckResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+
+	return res.Advices, nil
+}
+
+// login logs in with the service account, and caches the token until it is about to expire.
+func (s *BytebaseService) login(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expiresAt.Add(-bytebaseTokenRefreshMargin)) {
+		return s.token, nil
+	}
+
+	token, err := s.doLogin(ctx)
+	if err != nil {
+		return "", &BytebaseLoginError{Account: s.key, Err: err}
+	}
+	s.token = token
+	s.expiresAt = tokenExpiry(token, time.Now())
+	return s.token, nil
+}
+
+// invalidate drops the cached token if it is still the token rejected, so that the next request logs
+// in again. The token may have been refreshed by a concurrent request already.
+func (s *BytebaseService) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+func (s *BytebaseService) doLogin(ctx context.Context) (string, error) {
+	rb, err := json.Marshal(&bytebaseAuthRequest{
+		Email:    s.key,
+		Password: s.secret,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/auth/login", s.url), strings.NewReader(string(rb)))
+	if err != nil {
+		return "", err
+	}
+
+	body, err := s.doRequestWithToken(req, "")
+	if err != nil {
+		return "", err
+	}
+
+	res := &bytebaseAuthResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return "", err
+	}
+	if res.Token == "" {
+		return "", errors.New("missing token in the login response")
+	}
+
+	return res.Token, nil
+}
+
+// tokenExpiry returns the expiry in the exp claim of the JWT token, or bytebaseTokenDefaultTTL from now
+// if the token is not a JWT.
+func tokenExpiry(token string, now time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return now.Add(bytebaseTokenDefaultTTL)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return now.Add(bytebaseTokenDefaultTTL)
+	}
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil || claims.ExpiresAt == 0 {
+		return now.Add(bytebaseTokenDefaultTTL)
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// doRequest does the request with the cached token, and retries once with a new token if the token
+// is rejected with 401, e.g. revoked or expired earlier than its exp claim.
 func (s *BytebaseService) doRequest(req *http.Request) ([]byte, error) {
-	user, err := s.login()
+	token, err := s.login(req.Context())
 	if err != nil {
 		return nil, err
 	}
 
-	return s.doRequestWithToken(req, user.Token)
+	body, err := s.doRequestWithToken(req, token)
+	var bytebaseErr *BytebaseError
+	if !errors.As(err, &bytebaseErr) || bytebaseErr.StatusCode != http.StatusUnauthorized {
+		return body, err
+	}
+
+	s.invalidate(token)
+	if token, err = s.login(req.Context()); err != nil {
+		return nil, err
+	}
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return s.doRequestWithToken(req, token)
 }
 
 func (s *BytebaseService) doRequestWithToken(req *http.Request, token string) ([]byte, error) {
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -107,7 +624,7 @@ func (s *BytebaseService) doRequestWithToken(req *http.Request, token string) ([
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("status: %d, body: %s", res.StatusCode, body)
+		return nil, &BytebaseError{StatusCode: res.StatusCode, Body: string(body)}
 	}
 
 	return body, err
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/pkg/errors"
+)
+
+func TestBytebaseTokenCache(t *testing.T) {
+	var logins, revoked int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&logins, 1)
+		fmt.Fprintf(w, `{"token":"token-%d"}`, n)
+	})
+	mux.HandleFunc("/v1/sql/check", func(w http.ResponseWriter, r *http.Request) {
+		// Revoke the first token once.
+		if r.Header.Get("Authorization") == "Bearer token-1" && atomic.LoadInt32(&revoked) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"advices":[]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := NewBytebase(server.URL, "relay@service.bytebase.com", "secret", BytebaseAPIAuto)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.CheckSQL(context.Background(), &payload.SQLCheckRequest{Statement: "SELECT 1"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&logins); got != 1 {
+		t.Errorf("Expect 1 login for the concurrent requests, got %d", got)
+	}
+
+	atomic.StoreInt32(&revoked, 1)
+	if _, err := s.CheckSQL(context.Background(), &payload.SQLCheckRequest{Statement: "SELECT 1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&logins); got != 2 {
+		t.Errorf("Expect to login again after 401, got %d logins", got)
+	}
+}
+
+func TestBytebaseLoginError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	_, err := NewBytebase(server.URL, "relay@service.bytebase.com", "wrong", BytebaseAPIAuto).CheckSQL(context.Background(), &payload.SQLCheckRequest{Statement: "SELECT 1"})
+	var loginErr *BytebaseLoginError
+	if !errors.As(err, &loginErr) {
+		t.Fatalf("Expect BytebaseLoginError, got %v", err)
+	}
+	var bytebaseErr *BytebaseError
+	if !errors.As(err, &bytebaseErr) || bytebaseErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expect the 401 response wrapped, got %v", err)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":2000}`))
+	if got := tokenExpiry("header."+claims+".signature", now); !got.Equal(time.Unix(2000, 0)) {
+		t.Errorf("Expect the exp claim, got %v", got)
+	}
+	if got := tokenExpiry("opaque", now); !got.Equal(now.Add(bytebaseTokenDefaultTTL)) {
+		t.Errorf("Expect the default TTL, got %v", got)
+	}
+}
+
+func TestBytebaseCreateIssues(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		wantIssue  string
+		wantCalled []string
+	}{
+		{
+			name:       "v1",
+			version:    `{"version":"2.10.0"}`,
+			wantIssue:  "projects/db/issues/1",
+			wantCalled: []string{"/v1/actuator/info", "/v1/projects/db/sheets", "/v1/projects/db/plans", "/v1/projects/db/issues"},
+		},
+		{
+			name:       "legacy",
+			version:    `{"version":"1.15.0"}`,
+			wantIssue:  "legacy",
+			wantCalled: []string{"/v1/actuator/info", "/v1/issues"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var called []string
+			mux := http.NewServeMux()
+			record := func(pattern, response string, check func(body map[string]interface{})) {
+				mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					called = append(called, r.URL.Path)
+					mu.Unlock()
+					if check != nil {
+						body := map[string]interface{}{}
+						if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+							t.Error(err)
+						}
+						check(body)
+					}
+					_, _ = w.Write([]byte(response))
+				})
+			}
+			mux.HandleFunc("/v1/auth/login", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"token":"token"}`))
+			})
+			record("/v1/actuator/info", tc.version, nil)
+			record("/v1/projects/db/sheets", `{"name":"projects/db/sheets/1"}`, func(body map[string]interface{}) {
+				if body["content"] != base64.StdEncoding.EncodeToString([]byte("CREATE TABLE t (id INT);")) {
+					t.Errorf("Unexpected sheet content %v", body["content"])
+				}
+			})
+			record("/v1/projects/db/plans", `{"name":"projects/db/plans/1"}`, func(body map[string]interface{}) {
+				spec := body["steps"].([]interface{})[0].(map[string]interface{})["specs"].([]interface{})[0].(map[string]interface{})
+				config := spec["changeDatabaseConfig"].(map[string]interface{})
+				if config["target"] != "instances/prod/databases/orders" || config["sheet"] != "projects/db/sheets/1" || config["schemaVersion"] != "001" {
+					t.Errorf("Unexpected spec %v", spec)
+				}
+			})
+			record("/v1/projects/db/issues", `{"name":"projects/db/issues/1","plan":"projects/db/plans/1"}`, func(body map[string]interface{}) {
+				if body["plan"] != "projects/db/plans/1" || body["type"] != "DATABASE_CHANGE" {
+					t.Errorf("Unexpected issue %v", body)
+				}
+			})
+			record("/v1/issues", `{"name":"legacy"}`, func(body map[string]interface{}) {
+				if body["statement"] != "CREATE TABLE t (id INT);" {
+					t.Errorf("Unexpected legacy issue %v", body)
+				}
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			s := NewBytebase(server.URL, "relay@service.bytebase.com", "secret", BytebaseAPIAuto)
+			for i := 0; i < 2; i++ {
+				issueList, err := s.CreateIssues(context.Background(), &payload.MultiStepIssueCreate{
+					ProjectKey: "db",
+					Title:      "[Relay] Gerrit change 1",
+					Steps: []*payload.IssueStepCreate{
+						{
+							Title: "prod",
+							Changes: []*payload.IssueCreate{
+								{
+									ProjectKey:    "db",
+									Environment:   "prod",
+									Database:      "orders",
+									Name:          "[Alter schema] db/prod/orders##001##ddl.sql",
+									MigrationType: payload.Migrate,
+									Statement:     "CREATE TABLE t (id INT);",
+									SchemaVersion: "001",
+									Target:        "instances/prod/databases/orders",
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(issueList) != 1 || issueList[0].Name != tc.wantIssue {
+					t.Errorf("Expect issue %q, got %v", tc.wantIssue, issueList)
+				}
+			}
+			// The version is detected once.
+			want := append(tc.wantCalled, tc.wantCalled[1:]...)
+			if fmt.Sprint(called) != fmt.Sprint(want) {
+				t.Errorf("Expect calls %v, got %v", want, called)
+			}
+		})
+	}
+}
+
+func TestBytebaseCreateIssuesCleanup(t *testing.T) {
+	var sheets, deleted int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"token":"token"}`))
+	})
+	mux.HandleFunc("/v1/projects/db/sheets", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":"projects/db/sheets/%d"}`, atomic.AddInt32(&sheets, 1))
+	})
+	mux.HandleFunc("/v1/projects/db/sheets/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("Expect DELETE, got %s", r.Method)
+		}
+		atomic.AddInt32(&deleted, 1)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/v1/projects/db/plans", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	change := func(env string) *payload.IssueCreate {
+		return &payload.IssueCreate{ProjectKey: "db", Environment: env, Database: "orders", Statement: "SELECT 1", Target: "instances/" + env + "/databases/orders"}
+	}
+	s := NewBytebase(server.URL, "relay@service.bytebase.com", "secret", BytebaseAPIV1)
+	_, err := s.CreateIssues(context.Background(), &payload.MultiStepIssueCreate{
+		ProjectKey: "db",
+		Steps: []*payload.IssueStepCreate{
+			{Title: "test", Changes: []*payload.IssueCreate{change("test")}},
+			{Title: "prod", Changes: []*payload.IssueCreate{change("prod")}},
+		},
+	})
+	if err == nil {
+		t.Fatal("Expect error creating the plan")
+	}
+	if got := atomic.LoadInt32(&deleted); got != 2 {
+		t.Errorf("Expect the 2 sheets deleted, got %d", got)
+	}
+}
@@ -1,15 +1,20 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/util"
 	"github.com/pkg/errors"
 )
 
@@ -17,56 +22,307 @@ type GerritService struct {
 	url      string
 	username string
 	password string
+	// token is the bearer token, used instead of the basic auth if set.
+	token string
+	// cookie is sent as the Cookie header if set, e.g. the o=... cookie from .gitcookies.
+	cookie string
+	client *http.Client
+	// fileCache caches the file content by the request URL, it is nil if disabled.
+	fileCache *util.LRU[string, string]
+	// maxFileSize is the max size in bytes of the file content, 0 for no limit.
+	maxFileSize int64
 }
 
-const gerritResponsePrefix = ")]}'\n"
+// GerritOption configures the Gerrit service.
+type GerritOption func(*GerritService)
 
-// NewGerrit creates a Gerrit service
-func NewGerrit(url, username, password string) *GerritService {
-	return &GerritService{
-		url:      url,
+// WithGerritHTTPClient uses the client to send the requests instead of the default client.
+func WithGerritHTTPClient(client *http.Client) GerritOption {
+	return func(s *GerritService) {
+		s.client = client
+	}
+}
+
+// WithGerritTimeout sets the timeout of every request attempt.
+func WithGerritTimeout(timeout time.Duration) GerritOption {
+	return func(s *GerritService) {
+		client := *s.client
+		client.Timeout = timeout
+		s.client = &client
+	}
+}
+
+// WithGerritBearerToken authenticates with the bearer token instead of the basic auth, e.g. with the OAuth plugin.
+func WithGerritBearerToken(token string) GerritOption {
+	return func(s *GerritService) {
+		s.token = token
+	}
+}
+
+// WithGerritCookie sends the cookie with every request, e.g. the o=... cookie from .gitcookies.
+func WithGerritCookie(cookie string) GerritOption {
+	return func(s *GerritService) {
+		s.cookie = cookie
+	}
+}
+
+var (
+	// ErrGerritUnauthorized is matched by the GerritError with 401, the credentials are missing or invalid.
+	ErrGerritUnauthorized = errors.New("gerrit: unauthorized")
+	// ErrGerritForbidden is matched by the GerritError with 403, the account lacks the permission.
+	ErrGerritForbidden = errors.New("gerrit: forbidden")
+	// ErrGerritNotFound is matched by the GerritError with 404, the resource does not exist or is not visible.
+	ErrGerritNotFound = errors.New("gerrit: not found")
+	// ErrGerritConflict is matched by the GerritError with 409, the resource is in a conflicting state, e.g. the change is closed.
+	ErrGerritConflict = errors.New("gerrit: conflict")
+)
+
+// GerritError is the error of a non-200 response, use errors.Is with the ErrGerrit* sentinels to check the status.
+type GerritError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *GerritError) Error() string {
+	return fmt.Sprintf("status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Is reports whether the sentinel error matches the status code.
+func (e *GerritError) Is(target error) bool {
+	switch target {
+	case ErrGerritUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrGerritForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrGerritNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrGerritConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+const (
+	// gerritMaxAttempts is the max attempts of a GET request failed with 429 or 5xx.
+	gerritMaxAttempts = 3
+	// gerritRetryBackoff is the backoff before the first retry, doubled on every retry.
+	gerritRetryBackoff = 500 * time.Millisecond
+	// gerritMaxRetryAfter is the max delay requested by the Retry-After header to wait for, the request
+	// fails without retrying if the server requests a longer delay.
+	gerritMaxRetryAfter = 30 * time.Second
+	// gerritDefaultTimeout is the timeout of every request attempt if not configured.
+	gerritDefaultTimeout = 30 * time.Second
+)
+
+// GerritFileInfo is the information about a file in a revision.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#file-info
+type GerritFileInfo struct {
+	// Status is empty in the response if the file is modified, ListFilesInChange sets it to payload.GerritFileModified.
+	Status        payload.GerritFileStatus `json:"status"`
+	Binary        bool                     `json:"binary"`
+	OldPath       string                   `json:"old_path"`
+	LinesInserted int                      `json:"lines_inserted"`
+	LinesDeleted  int                      `json:"lines_deleted"`
+	SizeDelta     int64                    `json:"size_delta"`
+	Size          int64                    `json:"size"`
+}
+
+// GerritChangeInfo is the information about a change.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#change-info
+type GerritChangeInfo struct {
+	ID              string `json:"id"`
+	Project         string `json:"project"`
+	Branch          string `json:"branch"`
+	Topic           string `json:"topic"`
+	ChangeID        string `json:"change_id"`
+	Number          int    `json:"_number"`
+	Subject         string `json:"subject"`
+	Status          string `json:"status"`
+	SubmissionID    string `json:"submission_id"`
+	CurrentRevision string `json:"current_revision"`
+}
+
+// GerritCommitInfo is the information about a commit.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#commit-info
+type GerritCommitInfo struct {
+	Commit  string              `json:"commit"`
+	Parents []*GerritCommitInfo `json:"parents"`
+	Subject string              `json:"subject"`
+	Message string              `json:"message"`
+}
+
+// gerritResponsePrefix is prepended to the JSON responses to prevent XSSI.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api.html#output
+const gerritResponsePrefix = ")]}'"
+
+// NewGerrit creates a Gerrit service, authenticated with the basic auth of the username and password
+// unless overridden by the options.
+func NewGerrit(url, username, password string, opts ...GerritOption) *GerritService {
+	s := &GerritService{
+		url:      strings.TrimSuffix(url, "/"),
 		username: username,
 		password: password,
+		client:   &http.Client{Timeout: gerritDefaultTimeout},
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // ListFilesInChange lists changed files in a change.
 // Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#list-files
-func (s *GerritService) ListFilesInChange(ctx context.Context, changeKey, revisionKey string) (map[string]interface{}, error) {
+func (s *GerritService) ListFilesInChange(ctx context.Context, changeKey, revisionKey string) (map[string]*GerritFileInfo, error) {
 	url := fmt.Sprintf("%s/a/changes/%s/revisions/%s/files", s.url, changeKey, revisionKey)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
+	data := map[string]*GerritFileInfo{}
+	if err := s.getJSON(ctx, url, &data); err != nil {
 		return nil, err
 	}
-
-	bytes, err := s.doRequest(req)
-	if err != nil {
-		return nil, err
+	for _, info := range data {
+		if info.Status == "" {
+			info.Status = payload.GerritFileModified
+		}
 	}
+	return data, nil
+}
 
-	resp, err := parseGerritResponse(bytes)
-	if err != nil {
+// GetChange returns the change, the options request the additional fields, e.g. CURRENT_REVISION.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#get-change
+func (s *GerritService) GetChange(ctx context.Context, changeKey string, options ...string) (*GerritChangeInfo, error) {
+	query := url.Values{"o": options}
+	url := fmt.Sprintf("%s/a/changes/%s", s.url, changeKey)
+	if len(options) > 0 {
+		url += "?" + query.Encode()
+	}
+	change := &GerritChangeInfo{}
+	if err := s.getJSON(ctx, url, change); err != nil {
 		return nil, err
 	}
+	return change, nil
+}
 
-	data := map[string]interface{}{}
-	if err := json.Unmarshal(resp, &data); err != nil {
+// ListSubmittedTogether lists the changes submitted together with the change, including the change itself.
+// The changes not visible to the account are omitted.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#submitted-together
+func (s *GerritService) ListSubmittedTogether(ctx context.Context, changeKey string) ([]*GerritChangeInfo, error) {
+	url := fmt.Sprintf("%s/a/changes/%s/submitted_together?o=CURRENT_REVISION", s.url, changeKey)
+	var changes []*GerritChangeInfo
+	if err := s.getJSON(ctx, url, &changes); err != nil {
 		return nil, err
 	}
-
-	return data, nil
+	return changes, nil
 }
 
 // GetFileContent returns the file content in a change.
 // Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#get-content
 func (s *GerritService) GetFileContent(ctx context.Context, changeKey, revisionKey, filename string) (string, error) {
 	url := fmt.Sprintf("%s/a/changes/%s/revisions/%s/files/%s/content", s.url, changeKey, revisionKey, url.QueryEscape(filename))
+	return s.getContent(ctx, url, isCommitSHA(revisionKey))
+}
+
+// EnableFileCache caches the content of at most size files. Only the content at a commit SHA-1 is
+// cached, since it never changes.
+func (s *GerritService) EnableFileCache(size int) {
+	s.fileCache = util.NewLRU[string, string](size)
+}
+
+// SetMaxFileSize fails the file content fetches exceeding size bytes with ErrFileTooLarge, without reading
+// the content whole. 0 for no limit.
+func (s *GerritService) SetMaxFileSize(size int64) {
+	s.maxFileSize = size
+}
+
+// QueryChanges queries the changes visible to the account.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#list-changes
+func (s *GerritService) QueryChanges(ctx context.Context, query string) ([]*GerritChangeInfo, error) {
+	url := fmt.Sprintf("%s/a/changes/?q=%s", s.url, url.QueryEscape(query))
+	var changes []*GerritChangeInfo
+	if err := s.getJSON(ctx, url, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetCommit returns the commit in a project.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-projects.html#get-commit
+func (s *GerritService) GetCommit(ctx context.Context, project, commit string) (*GerritCommitInfo, error) {
+	url := fmt.Sprintf("%s/a/projects/%s/commits/%s", s.url, url.QueryEscape(project), commit)
+	info := &GerritCommitInfo{}
+	if err := s.getJSON(ctx, url, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ListCommits walks the first parent chain from the until commit back to the since commit, and returns
+// the commits after since in chronological order. It fails if since is not reached within max commits
+// or is not an ancestor of until.
+func (s *GerritService) ListCommits(ctx context.Context, project, since, until string, max int) ([]*GerritCommitInfo, error) {
+	var commitList []*GerritCommitInfo
+	for revision := until; revision != since; {
+		if len(commitList) == max {
+			return nil, errors.Errorf("more than %d commits between %s and %s", max, since, until)
+		}
+		commit, err := s.GetCommit(ctx, project, revision)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) == 0 {
+			return nil, errors.Errorf("%s is not an ancestor of %s", since, until)
+		}
+		commitList = append(commitList, commit)
+		revision = commit.Parents[0].Commit
+	}
+
+	for i, j := 0, len(commitList)-1; i < j; i, j = i+1, j-1 {
+		commitList[i], commitList[j] = commitList[j], commitList[i]
+	}
+	return commitList, nil
+}
+
+// ListFilesInCommit lists the files changed in a commit compared to its first parent.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-projects.html#list-files
+func (s *GerritService) ListFilesInCommit(ctx context.Context, project, commit string) (map[string]*GerritFileInfo, error) {
+	url := fmt.Sprintf("%s/a/projects/%s/commits/%s/files/", s.url, url.QueryEscape(project), commit)
+	data := map[string]*GerritFileInfo{}
+	if err := s.getJSON(ctx, url, &data); err != nil {
+		return nil, err
+	}
+	for _, info := range data {
+		if info.Status == "" {
+			info.Status = payload.GerritFileModified
+		}
+	}
+	return data, nil
+}
+
+// GetFileContentInCommit returns the file content in a commit.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-projects.html#get-content-from-commit
+func (s *GerritService) GetFileContentInCommit(ctx context.Context, project, commit, filename string) (string, error) {
+	url := fmt.Sprintf("%s/a/projects/%s/commits/%s/files/%s/content", s.url, url.QueryEscape(project), commit, url.QueryEscape(filename))
+	return s.getContent(ctx, url, isCommitSHA(commit))
+}
+
+// getContent gets the base64 encoded file content from the url, and caches it if cacheable.
+func (s *GerritService) getContent(ctx context.Context, url string, cacheable bool) (string, error) {
+	cacheable = cacheable && s.fileCache != nil
+	if cacheable {
+		if content, ok := s.fileCache.Get(url); ok {
+			return content, nil
+		}
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return "", err
 	}
 
-	bytes, err := s.doRequest(req)
+	// The content is base64 encoded, about 4/3 of the file size.
+	var limit int64
+	if s.maxFileSize > 0 {
+		limit = int64(base64.StdEncoding.EncodedLen(int(s.maxFileSize)))
+	}
+	bytes, err := s.doRequest(req, limit)
 	if err != nil {
 		return "", err
 	}
@@ -75,29 +331,153 @@ func (s *GerritService) GetFileContent(ctx context.Context, changeKey, revisionK
 	if err != nil {
 		return "", err
 	}
+	if s.maxFileSize > 0 && int64(len(decoded)) > s.maxFileSize {
+		return "", errors.Wrapf(ErrFileTooLarge, "%d bytes exceeds %d bytes", len(decoded), s.maxFileSize)
+	}
 
+	if cacheable {
+		s.fileCache.Add(url, string(decoded))
+	}
 	return string(decoded), nil
 }
 
-func (s *GerritService) doRequest(req *http.Request) ([]byte, error) {
-	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", s.basicAuth()))
+// getJSON gets the url and decodes the JSON response into v.
+func (s *GerritService) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	body, err := s.doRequest(req, 0)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(parseGerritResponse(body), v)
+}
+
+// ListEventsSince lists the events created since the time, one JSON encoded event per line.
+// Requires the events-log plugin.
+// Docs: https://gerrit.googlesource.com/plugins/events-log/+/refs/heads/master/src/main/resources/Documentation/rest-api-events.md
+func (s *GerritService) ListEventsSince(ctx context.Context, since time.Time) ([][]byte, error) {
+	url := fmt.Sprintf("%s/a/plugins/events-log/events/?t1=%s", s.url, url.QueryEscape(since.UTC().Format("2006-01-02 15:04:05")))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	body, err := s.doRequest(req, 0)
 	if err != nil {
 		return nil, err
 	}
+	body = parseGerritResponse(body)
+
+	var lines [][]byte
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// PostReview sets a review on a revision, including the inline comments and the label votes.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#set-review
+func (s *GerritService) PostReview(ctx context.Context, changeKey, revisionKey string, review *payload.GerritReviewInput) error {
+	rb, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/a/changes/%s/revisions/%s/review", s.url, changeKey, revisionKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(rb))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if _, err := s.doRequest(req, 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// doRequest does the request, and retries the GET request failed with 429 or 5xx with exponential backoff.
+// The request fails with ErrFileTooLarge if limit is positive and the response body exceeds limit bytes.
+func (s *GerritService) doRequest(req *http.Request, limit int64) ([]byte, error) {
+	switch {
+	case s.token != "":
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	case s.username != "" || s.password != "":
+		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", s.basicAuth()))
+	}
+	if s.cookie != "" {
+		req.Header.Set("Cookie", s.cookie)
+	}
+
+	backoff := gerritRetryBackoff
+	for attempt := 1; ; attempt++ {
+		body, retryAfter, err := s.doRequestOnce(req, limit)
+		if err == nil || retryAfter < 0 || req.Method != http.MethodGet || attempt == gerritMaxAttempts {
+			return body, err
+		}
+		if retryAfter > gerritMaxRetryAfter {
+			return body, errors.Wrapf(err, "Retry-After %s exceeds %s", retryAfter, gerritMaxRetryAfter)
+		}
+		if retryAfter < backoff {
+			retryAfter = backoff
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(retryAfter).After(deadline) {
+			return body, err
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(retryAfter):
+		}
+		backoff *= 2
+	}
+}
+
+// doRequestOnce does the request once, retryAfter is negative if the failure is not retryable,
+// otherwise it is the delay requested by the Retry-After header, or 0 if missing.
+func (s *GerritService) doRequestOnce(req *http.Request, limit int64) (body []byte, retryAfter time.Duration, err error) {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer res.Body.Close()
+
+	body, tooLarge, err := readBody(res.Body, limit)
+	if err != nil {
+		return nil, -1, err
+	}
+	if tooLarge && res.StatusCode == http.StatusOK {
+		return nil, -1, errors.Wrapf(ErrFileTooLarge, "response exceeds %d bytes", limit)
+	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("status: %d, body: %s", res.StatusCode, body)
+		err = &GerritError{StatusCode: res.StatusCode, Body: string(body)}
+		if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+			return nil, -1, err
+		}
+		if seconds, parseErr := strconv.Atoi(res.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+			return nil, time.Duration(seconds) * time.Second, err
+		}
+		return nil, 0, err
 	}
 
-	return body, err
+	return body, 0, nil
+}
+
+// isCommitSHA reports whether the revision is a full commit SHA-1 instead of a name like "current".
+func isCommitSHA(revision string) bool {
+	if len(revision) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(revision)
+	return err == nil
 }
 
 func (s *GerritService) basicAuth() string {
@@ -105,18 +485,14 @@ func (s *GerritService) basicAuth() string {
 	return base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
-// All responses from Gerrit have a specific prefix
-// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api.html
-func parseGerritResponse(input []byte) ([]byte, error) {
-	str := string(input)
-	if !strings.HasPrefix(str, gerritResponsePrefix) {
-		return nil, errors.Errorf("invalid response, missing prefix %s", gerritResponsePrefix)
+// parseGerritResponse strips the XSSI prefix line from the JSON response. The response is returned as is
+// if the prefix is missing, e.g. the plain text responses.
+// Docs: https://gerrit-review.googlesource.com/Documentation/rest-api.html#output
+func parseGerritResponse(input []byte) []byte {
+	if !bytes.HasPrefix(input, []byte(gerritResponsePrefix)) {
+		return input
 	}
-
-	sec := strings.Split(str, gerritResponsePrefix)
-	if len(sec) != 2 {
-		return nil, errors.Errorf("invalid response %s", str)
-	}
-
-	return []byte(sec[1]), nil
+	input = bytes.TrimPrefix(input, []byte(gerritResponsePrefix))
+	input = bytes.TrimPrefix(input, []byte("\r"))
+	return bytes.TrimPrefix(input, []byte("\n"))
 }
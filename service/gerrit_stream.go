@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// GerritStream consumes the Gerrit events from `gerrit stream-events` over SSH.
+// Docs: https://gerrit-review.googlesource.com/Documentation/cmd-stream-events.html
+type GerritStream struct {
+	address string
+	config  *ssh.ClientConfig
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between reconnections.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// maxGerritEventSize is the max size of a single event line, large enough for a change with long commit message.
+const maxGerritEventSize = 4 * 1024 * 1024
+
+// NewGerritStream creates a Gerrit stream, address is the host:port of the Gerrit SSH daemon.
+func NewGerritStream(address string, config *ssh.ClientConfig) *GerritStream {
+	return &GerritStream{
+		address:    address,
+		config:     config,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+// Run consumes the events until ctx is done, reconnects with exponential backoff if the connection is lost.
+// After every connection, connected is called before any event of the connection is handled, it is used
+// to catch up with the events missed while disconnected. Each event line is passed to handle in order.
+func (s *GerritStream) Run(ctx context.Context, connected func(ctx context.Context), handle func(ctx context.Context, line []byte)) {
+	backoff := s.MinBackoff
+	for {
+		start := time.Now()
+		err := s.consume(ctx, connected, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		// Reset the backoff if the connection has been healthy for a while.
+		if time.Since(start) > s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+		fmt.Printf("Gerrit stream-events from %s disconnected: %v, reconnecting in %s\n", s.address, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+func (s *GerritStream) consume(ctx context.Context, connected func(ctx context.Context), handle func(ctx context.Context, line []byte)) error {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return errors.Wrap(err, "dial")
+	}
+	defer conn.Close()
+
+	// Close the connection to unblock the handshake or the scanner once ctx is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.address, s.config)
+	if err != nil {
+		return errors.Wrap(err, "handshake")
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "new session")
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "stdout pipe")
+	}
+	if err := session.Start("gerrit stream-events"); err != nil {
+		return errors.Wrap(err, "start stream-events")
+	}
+	// The events are buffered by the session while catching up, so nothing is lost in between.
+	connected(ctx)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxGerritEventSize)
+	for scanner.Scan() {
+		handle(ctx, scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "read events")
+	}
+	return errors.New("stream closed")
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serveGerritStream serves `gerrit stream-events` on a local SSH server, the n-th connection
+// emits the n-th batch of the canned event lines and then closes.
+func serveGerritStream(t *testing.T, clientKey ssh.PublicKey, batches [][]string) (string, ssh.PublicKey) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "relay" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for _, batch := range batches {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			serveGerritStreamConn(t, conn, config, batch)
+		}
+	}()
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+func serveGerritStreamConn(t *testing.T, conn net.Conn, config *ssh.ServerConfig, lines []string) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		t.Errorf("Handshake failed: %v", err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			t.Errorf("Accept channel failed: %v", err)
+			return
+		}
+		for req := range requests {
+			// The exec payload is the length prefixed command.
+			if req.Type != "exec" || string(req.Payload[4:]) != "gerrit stream-events" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			for _, line := range lines {
+				_, _ = channel.Write([]byte(line + "\n"))
+			}
+			_ = channel.Close()
+			return
+		}
+	}
+}
+
+func TestGerritStreamReconnect(t *testing.T) {
+	_, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, hostKey := serveGerritStream(t, clientSigner.PublicKey(), [][]string{
+		{`{"type":"patchset-created","eventCreatedOn":1}`, `{"type":"change-merged","eventCreatedOn":2}`},
+		{`{"type":"change-merged","eventCreatedOn":3}`},
+	})
+
+	s := NewGerritStream(address, &ssh.ClientConfig{
+		User:            "relay",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	})
+	s.MinBackoff = 10 * time.Millisecond
+	s.MaxBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var got []string
+	connections := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(context.Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			connections++
+			got = append(got, "connected")
+		}, func(_ context.Context, line []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, string(line))
+			if len(got) == 5 {
+				cancel()
+			}
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("Run does not return after the context is done")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"connected",
+		`{"type":"patchset-created","eventCreatedOn":1}`,
+		`{"type":"change-merged","eventCreatedOn":2}`,
+		"connected",
+		`{"type":"change-merged","eventCreatedOn":3}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expect %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expect %q at %d, got %q", want[i], i, got[i])
+		}
+	}
+	if connections != 2 {
+		t.Errorf("Expect 2 connections, got %d", connections)
+	}
+}
+
+func TestGerritStreamStop(t *testing.T) {
+	// The server accepts the connection but never completes the SSH handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	s := NewGerritStream(listener.Addr().String(), &ssh.ClientConfig{
+		User:            "relay",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(context.Context) {}, func(context.Context, []byte) {})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run does not return after the context is done")
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseGerritResponse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: ")]}'\n{\"subject\":\")]}'\"}", want: "{\"subject\":\")]}'\"}"},
+		{input: ")]}'\r\n[]", want: "[]"},
+		{input: "{}", want: "{}"},
+	}
+	for _, test := range tests {
+		if got := string(parseGerritResponse([]byte(test.input))); got != test.want {
+			t.Errorf("parseGerritResponse(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestGerritGetChange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a/changes/42" {
+			t.Errorf("Expect path /a/changes/42, got %s", r.URL.Path)
+		}
+		if got := r.URL.Query()["o"]; len(got) != 2 || got[0] != "CURRENT_REVISION" || got[1] != "MESSAGES" {
+			t.Errorf("Expect options CURRENT_REVISION and MESSAGES, got %v", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Expect the bearer token, got %q", got)
+		}
+		if got := r.Header.Get("Cookie"); got != "o=cookie" {
+			t.Errorf("Expect the cookie, got %q", got)
+		}
+		_, _ = w.Write([]byte(")]}'\n{\"_number\":42,\"subject\":\"Fix )]}' in the subject\",\"status\":\"MERGED\"}"))
+	}))
+	defer server.Close()
+
+	s := NewGerrit(server.URL+"/", "", "", WithGerritBearerToken("secret"), WithGerritCookie("o=cookie"))
+	change, err := s.GetChange(context.Background(), "42", "CURRENT_REVISION", "MESSAGES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Number != 42 || change.Subject != "Fix )]}' in the subject" || change.Status != "MERGED" {
+		t.Errorf("Unexpected change %+v", change)
+	}
+}
+
+func TestGerritError(t *testing.T) {
+	tests := []struct {
+		code int
+		want error
+	}{
+		{code: http.StatusUnauthorized, want: ErrGerritUnauthorized},
+		{code: http.StatusForbidden, want: ErrGerritForbidden},
+		{code: http.StatusNotFound, want: ErrGerritNotFound},
+		{code: http.StatusConflict, want: ErrGerritConflict},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); !ok || user != "relay" || password != "secret" {
+				t.Errorf("Expect the basic auth, got %q %q", user, password)
+			}
+			w.WriteHeader(test.code)
+		}))
+
+		_, err := NewGerrit(server.URL, "relay", "secret").GetChange(context.Background(), "42")
+		server.Close()
+		if !errors.Is(err, test.want) {
+			t.Errorf("Expect %v for status %d, got %v", test.want, test.code, err)
+		}
+		var gerritErr *GerritError
+		if !errors.As(err, &gerritErr) || gerritErr.StatusCode != test.code {
+			t.Errorf("Expect GerritError with status %d, got %v", test.code, err)
+		}
+	}
+}
+
+func TestGerritRetry(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(")]}'\n[{\"_number\":1},{\"_number\":2}]"))
+	}))
+	defer server.Close()
+
+	changes, err := NewGerrit(server.URL, "relay", "secret").QueryChanges(context.Background(), "topic:foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("Expect 2 changes, got %d", len(changes))
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("Expect 2 attempts, got %d", got)
+	}
+}
+
+func TestGerritRetryAfterTooLong(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := NewGerrit(server.URL, "relay", "secret").QueryChanges(context.Background(), "topic:foo")
+	var gerritErr *GerritError
+	if !errors.As(err, &gerritErr) || gerritErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expect the 429 error, got %v", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("Expect no retry after the long Retry-After, got %d attempts", got)
+	}
+}
+
+func TestGerritListCommits(t *testing.T) {
+	parents := map[string]string{"c3": "c2", "c2": "c1", "c1": "c0"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commit := r.URL.Path[len("/a/projects/db/commits/"):]
+		_, _ = w.Write([]byte(")]}'\n{\"commit\":\"" + commit + "\",\"parents\":[{\"commit\":\"" + parents[commit] + "\"}]}"))
+	}))
+	defer server.Close()
+
+	s := NewGerrit(server.URL, "relay", "secret")
+	commits, err := s.ListCommits(context.Background(), "db", "c0", "c3", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, commit := range commits {
+		got = append(got, commit.Commit)
+	}
+	if len(got) != 3 || got[0] != "c1" || got[1] != "c2" || got[2] != "c3" {
+		t.Errorf("Expect [c1 c2 c3], got %v", got)
+	}
+
+	if _, err := s.ListCommits(context.Background(), "db", "c0", "c3", 2); err == nil {
+		t.Error("Expect error for more than 2 commits")
+	}
+}
+
+func TestGerritMaxFileSize(t *testing.T) {
+	tests := []struct {
+		size    int
+		wantErr bool
+	}{
+		{size: 100},
+		{size: 101, wantErr: true},
+		// The content is not read whole.
+		{size: 10 << 20, wantErr: true},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("-", test.size)))))
+		}))
+
+		s := NewGerrit(server.URL, "relay", "secret")
+		s.SetMaxFileSize(100)
+		content, err := s.GetFileContent(context.Background(), "42", "current", "db/init.sql")
+		if test.wantErr {
+			if !errors.Is(err, ErrFileTooLarge) {
+				t.Errorf("Expect ErrFileTooLarge for %d bytes, got %v", test.size, err)
+			}
+		} else if err != nil || len(content) != test.size {
+			t.Errorf("Expect %d bytes, got %d, %v", test.size, len(content), err)
+		}
+		server.Close()
+	}
+}
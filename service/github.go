@@ -0,0 +1,308 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytebase/relay/payload"
+)
+
+type GitHubService struct {
+	url   string
+	token string
+	// app is set if the service authenticates as a GitHub App installation.
+	app *githubApp
+	// maxFileSize is the max size in bytes of the file content, 0 for no limit.
+	maxFileSize int64
+}
+
+type githubApp struct {
+	id             int64
+	installationID int64
+	privateKey     *rsa.PrivateKey
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+type githubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// githubTokenRefreshWindow is how long before the expiry an installation token is refreshed.
+const githubTokenRefreshWindow = 5 * time.Minute
+
+// GitHubFile is the file changed in a pull request or between two commits.
+type GitHubFile struct {
+	Filename string                   `json:"filename"`
+	Status   payload.GitHubFileStatus `json:"status"`
+	// PreviousFilename is the file path before the rename or copy.
+	PreviousFilename string `json:"previous_filename"`
+}
+
+// GitHubComparison is the comparison between two commits.
+type GitHubComparison struct {
+	Files []*GitHubFile `json:"files"`
+}
+
+// NewGitHub creates a GitHub service, url is the REST API base URL such as https://api.github.com.
+func NewGitHub(url, token string) *GitHubService {
+	return &GitHubService{
+		url:   strings.TrimSuffix(url, "/"),
+		token: token,
+	}
+}
+
+// NewGitHubApp creates a GitHub service authenticating as the installation of a GitHub App,
+// privateKey is the PEM encoded private key generated on the GitHub App settings page.
+func NewGitHubApp(url string, appID, installationID int64, privateKey []byte) (*GitHubService, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("invalid GitHub App private key, expect PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		pkcs8, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, errors.Wrap(err, "parse GitHub App private key")
+		}
+		rsaKey, ok := pkcs8.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.Errorf("invalid GitHub App private key type %T, expect RSA", pkcs8)
+		}
+		key = rsaKey
+	}
+
+	return &GitHubService{
+		url: strings.TrimSuffix(url, "/"),
+		app: &githubApp{
+			id:             appID,
+			installationID: installationID,
+			privateKey:     key,
+		},
+	}, nil
+}
+
+// ListPullRequestFiles lists the changed files in a pull request, repo is in the form of "owner/name".
+// Docs: https://docs.github.com/en/rest/pulls/pulls#list-pull-requests-files
+func (s *GitHubService) ListPullRequestFiles(ctx context.Context, repo string, number int) ([]*GitHubFile, error) {
+	var result []*GitHubFile
+	// GitHub returns at most 3000 files for a pull request.
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/repos/%s/pulls/%d/files?per_page=100&page=%d", s.url, repo, number, page)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		body, err := s.doRequest(req, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var files []*GitHubFile
+		if err := json.Unmarshal(body, &files); err != nil {
+			return nil, err
+		}
+		result = append(result, files...)
+		if len(files) < 100 {
+			return result, nil
+		}
+	}
+}
+
+// CompareCommits lists the files changed between the base and the head commits, repo is in the form
+// of "owner/name". GitHub lists at most 300 files of the comparison.
+// Docs: https://docs.github.com/en/rest/commits/commits#compare-two-commits
+func (s *GitHubService) CompareCommits(ctx context.Context, repo, base, head string) ([]*GitHubFile, error) {
+	url := fmt.Sprintf("%s/repos/%s/compare/%s...%s", s.url, repo, url.PathEscape(base), url.PathEscape(head))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	body, err := s.doRequest(req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &GitHubComparison{}
+	if err := json.Unmarshal(body, comparison); err != nil {
+		return nil, err
+	}
+	return comparison.Files, nil
+}
+
+// GetFileContent returns the raw file content at the ref, repo is in the form of "owner/name".
+// Docs: https://docs.github.com/en/rest/repos/contents#get-repository-content
+func (s *GitHubService) GetFileContent(ctx context.Context, repo, ref, filename string) (string, error) {
+	segments := strings.Split(filename, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	url := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", s.url, repo, strings.Join(segments, "/"), url.QueryEscape(ref))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw")
+
+	body, err := s.doRequest(req, s.maxFileSize)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+// CreateCommitComment creates a comment on the commit, repo is in the form of "owner/name".
+// Docs: https://docs.github.com/en/rest/commits/comments#create-a-commit-comment
+func (s *GitHubService) CreateCommitComment(ctx context.Context, repo, sha string, comment *payload.GitHubCommitCommentInput) error {
+	rb, err := json.Marshal(comment)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/commits/%s/comments", s.url, repo, url.PathEscape(sha))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(rb))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	if _, err := s.doRequest(req, 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetMaxFileSize fails the file content fetches exceeding size bytes with ErrFileTooLarge, without reading
+// the content whole. 0 for no limit.
+func (s *GitHubService) SetMaxFileSize(size int64) {
+	s.maxFileSize = size
+}
+
+// doRequest does the request, it fails with ErrFileTooLarge if limit is positive and the response body
+// exceeds limit bytes.
+func (s *GitHubService) doRequest(req *http.Request, limit int64) ([]byte, error) {
+	token, err := s.accessToken(req.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "get GitHub access token")
+	}
+	return s.doRequestWithToken(req, token, limit)
+}
+
+func (s *GitHubService) doRequestWithToken(req *http.Request, token string, limit int64) ([]byte, error) {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, tooLarge, err := readBody(res.Body, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return nil, errors.Errorf("status: %d, body: %s", res.StatusCode, body)
+	}
+	if tooLarge {
+		return nil, errors.Wrapf(ErrFileTooLarge, "exceeds %d bytes", limit)
+	}
+
+	return body, err
+}
+
+// accessToken returns the personal token, or the cached installation token of the GitHub App
+// which is exchanged again when it is about to expire.
+func (s *GitHubService) accessToken(ctx context.Context) (string, error) {
+	if s.app == nil {
+		return s.token, nil
+	}
+
+	s.app.mu.Lock()
+	defer s.app.mu.Unlock()
+	if s.app.token != "" && time.Now().Add(githubTokenRefreshWindow).Before(s.app.expiresAt) {
+		return s.app.token, nil
+	}
+
+	jwt, err := s.app.jwt(time.Now())
+	if err != nil {
+		return "", err
+	}
+	// Docs: https://docs.github.com/en/rest/apps/apps#create-an-installation-access-token-for-an-app
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", s.url, s.app.installationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	body, err := s.doRequestWithToken(req, jwt, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var token githubInstallationToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	s.app.token, s.app.expiresAt = token.Token, token.ExpiresAt
+	return s.app.token, nil
+}
+
+// jwt returns the RS256 signed JSON Web Token to authenticate as the GitHub App.
+// Docs: https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (app *githubApp) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		// Backdate to allow the clock drift.
+		"iat": now.Add(-time.Minute).Unix(),
+		// GitHub allows at most 10 minutes.
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprintf("%d", app.id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, app.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "sign JWT")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytebase/relay/payload"
+)
+
+func TestGitHubAppInstallationToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var exchanges int32
+	mux := http.NewServeMux()
+	// GitHub Enterprise serves the REST API under /api/v3.
+	mux.HandleFunc("/api/v3/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expect POST, got %s", r.Method)
+		}
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err := verifyJWT(&key.PublicKey, jwt, "7"); err != nil {
+			t.Errorf("Invalid JWT: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&exchanges, 1)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(githubInstallationToken{
+			Token:     fmt.Sprintf("token-%d", n),
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	})
+	mux.HandleFunc("/api/v3/repos/bytebase/relay/contents/db/1.sql", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("ref"); got != "abc" {
+			t.Errorf("Expect ref %q, got %q", "abc", got)
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s, err := NewGitHubApp(server.URL+"/api/v3/", 7, 42, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		got, err := s.GetFileContent(ctx, "bytebase/relay", "abc", "db/1.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Bearer token-1"; got != want {
+			t.Errorf("Expect %q, got %q", want, got)
+		}
+	}
+	if n := atomic.LoadInt32(&exchanges); n != 1 {
+		t.Errorf("Expect the installation token to be cached, got %d exchanges", n)
+	}
+
+	// The token about to expire is refreshed.
+	s.app.expiresAt = time.Now().Add(time.Minute)
+	got, err := s.GetFileContent(ctx, "bytebase/relay", "abc", "db/1.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Bearer token-2"; got != want {
+		t.Errorf("Expect %q, got %q", want, got)
+	}
+}
+
+func TestNewGitHubAppInvalidKey(t *testing.T) {
+	if _, err := NewGitHubApp("https://api.github.com", 7, 42, []byte("not a key")); err == nil {
+		t.Error("Expect error for the invalid private key")
+	}
+}
+
+func verifyJWT(key *rsa.PublicKey, jwt, issuer string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("expect 3 parts, got %d", len(parts))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return err
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return err
+	}
+	if claims.Iss != issuer {
+		return fmt.Errorf("expect issuer %q, got %q", issuer, claims.Iss)
+	}
+	if now := time.Now().Unix(); claims.Iat > now || claims.Exp <= now {
+		return fmt.Errorf("expired claims %+v", claims)
+	}
+	return nil
+}
+
+func TestGitHubMaxFileSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("-", 10<<20)))
+	}))
+	defer server.Close()
+
+	s := NewGitHub(server.URL, "secret")
+	s.SetMaxFileSize(100)
+	if _, err := s.GetFileContent(context.Background(), "bytebase/relay", "main", "db/init.sql"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Expect ErrFileTooLarge, got %v", err)
+	}
+	s.SetMaxFileSize(0)
+	content, err := s.GetFileContent(context.Background(), "bytebase/relay", "main", "db/init.sql")
+	if err != nil || len(content) != 10<<20 {
+		t.Errorf("Expect the whole content without the limit, got %d, %v", len(content), err)
+	}
+}
+
+func TestGitHubCreateCommitComment(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comment := &payload.GitHubCommitCommentInput{}
+		if err := json.NewDecoder(r.Body).Decode(comment); err != nil {
+			t.Error(err)
+		}
+		got = fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, comment.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	s := NewGitHub(server.URL, "secret")
+	if err := s.CreateCommitComment(context.Background(), "bytebase/relay", "0123456", &payload.GitHubCommitCommentInput{Body: "Found"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "POST /repos/bytebase/relay/commits/0123456/comments Found"; got != want {
+		t.Errorf("Expect %q, got %q", want, got)
+	}
+}
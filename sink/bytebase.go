@@ -3,10 +3,14 @@ package sink
 import (
 	"context"
 	"fmt"
-	"regexp"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
 	"github.com/bytebase/relay/service"
 	flag "github.com/spf13/pflag"
 )
@@ -16,36 +20,69 @@ var (
 )
 
 var (
-	bytebaseURL            string
-	bytebaseServiceAccount string
-	bytebaseServiceKey     string
-	// hard code for demo
-	issueNameTemplate string = "[%s] %s"
-	filePathTemplate  string = "{{PROJECT_KEY}}/{{ENV_NAME}}/{{DB_NAME}}##{{VERSION}}##{{TYPE}}##{{DESCRIPTION}}.sql"
-	placeholderRegexp string = `[^\\/?%*:|"<>]+`
-	placeholderList          = []string{
-		"PROJECT_KEY",
-		"ENV_NAME",
-		"VERSION",
-		"DB_NAME",
-		"TYPE",
-		"DESCRIPTION",
-	}
+	bytebaseURL                string
+	bytebaseServiceAccount     string
+	bytebaseServiceKey         string
+	bytebaseReviewLabel        string
+	bytebaseAPIVersion         string
+	bytebaseRollout            bool
+	bytebaseCheckExisting      bool
+	bytebaseFilePathTemplate   string
+	bytebaseSchemaFileTemplate string
+	bytebaseUnmatchedFiles     string
+	bytebaseVersionOrder       string
+	bytebaseVersionFormat      string
+	bytebaseSQLCheck           string
+	// defaultTemplate is the compiled --bytebase-file-path-template, used by the routes without their own template.
+	defaultTemplate *route.Template
+	// defaultSchemaTemplate is the compiled --bytebase-schema-file-template, nil if not set.
+	defaultSchemaTemplate *route.Template
+	issueNameTemplate     string = "[%s] %s"
 )
 
 func init() {
 	flag.StringVar(&bytebaseURL, "bytebase-url", "http://localhost:8080", "The Bytebase service URL")
 	flag.StringVar(&bytebaseServiceAccount, "bytebase-service-account", "", "The Bytebase service account name")
 	flag.StringVar(&bytebaseServiceKey, "bytebase-service-key", "", "The Bytebase service account key")
+	flag.StringVar(&bytebaseAPIVersion, "bytebase-api-version", string(service.BytebaseAPIAuto), "The Bytebase API used to create the issues, auto to detect by the server version, v1 or legacy")
+	flag.BoolVar(&bytebaseCheckExisting, "bytebase-check-existing", true, "Look up the versions in Bytebase to skip the changes already applied or planned, not supported by the legacy API")
+	flag.BoolVar(&bytebaseRollout, "bytebase-rollout", true, "Create the rollout of every issue created with the v1 API, the rollout policy of the environment decides whether it runs")
+	flag.StringVar(&bytebaseFilePathTemplate, "bytebase-file-path-template", route.DefaultFilePathTemplate, "The file path template to parse the Bytebase project, environment, database, version and type from, overridden by the route")
+	flag.StringVar(&bytebaseSchemaFileTemplate, "bytebase-schema-file-template", "", "The file path template of the schema files holding the desired schema of the databases, applied as SDL, overridden by the route")
+	flag.StringVar(&bytebaseUnmatchedFiles, "bytebase-unmatched-files", string(route.UnmatchedFilesWarn), "The policy for the SQL files not matching the file path template, ignore, warn to skip and report them, or fail the event, overridden by the route")
+	flag.StringVar(&bytebaseVersionOrder, "bytebase-version-order", string(route.VersionOrderStrict), "The rule for the versions older than the applied versions, strict to reject them, out-of-order to apply and report them, or sequential to reject them and the gaps between the versions, overridden by the route")
+	flag.StringVar(&bytebaseVersionFormat, "bytebase-version-format", string(route.VersionFormatNatural), "The format of the versions, natural, semver or timestamp, overridden by the route")
+	flag.StringVar(&bytebaseSQLCheck, "bytebase-sql-check", string(route.SQLCheckOff), "The policy for the SQL check before creating the issues, off, block to create no issue on errors, annotate the issues with the findings, or report the findings, overridden by the route")
+	flag.StringVar(&bytebaseReviewLabel, "bytebase-review-label", "Verified", "The Gerrit label voted with the SQL check result, e.g. Verified or Code-Review")
+}
+
+// Gerrit is the Gerrit hooker relaying the changes to the Bytebase sinker.
+type Gerrit interface {
+	// Route returns the route by name, or nil if not found.
+	Route(name string) *route.Route
+	// PostReview posts a review to a revision of a Gerrit change.
+	PostReview(ctx context.Context, changeID, revisionID string, review *payload.GerritReviewInput) error
 }
 
-// NewBytebase creates a Bytebase sinker
-func NewBytebase() Sinker {
-	return &bytebaseSinker{}
+// GitHub is the GitHub migration hooker relaying the SQL files to the Bytebase sinker.
+type GitHub interface {
+	// CreateCommitComment creates a comment on a commit, repo is in the form of "owner/name".
+	CreateCommitComment(ctx context.Context, repo, sha string, comment *payload.GitHubCommitCommentInput) error
+}
+
+// NewBytebase creates a Bytebase sinker, the gerrit finds the routes of the Gerrit messages
+// and posts the reviews back to the changes, and the github posts the comments back to the commits.
+func NewBytebase(gerrit Gerrit, github GitHub) Sinker {
+	return &bytebaseSinker{gerrit: gerrit, github: github}
 }
 
 type bytebaseSinker struct {
 	bytebaseService *service.BytebaseService
+	gerrit          Gerrit
+	github          GitHub
+	// mountOnce mounts the sinker once, since it is shared by the Gerrit and the GitHub migration hookers.
+	mountOnce sync.Once
+	mountErr  error
 }
 
 type migrationInfo struct {
@@ -56,9 +93,37 @@ type migrationInfo struct {
 	Description string
 	Project     string
 	Name        string
+	// Target is the database or the database group resource name to apply the migration.
+	Target string
 }
 
 func (sinker *bytebaseSinker) Mount() error {
+	sinker.mountOnce.Do(func() {
+		sinker.mountErr = sinker.mount()
+	})
+	return sinker.mountErr
+}
+
+func (sinker *bytebaseSinker) mount() error {
+	if err := compileDefaultTemplates(); err != nil {
+		return err
+	}
+	if err := route.UnmatchedFilesPolicy(bytebaseUnmatchedFiles).Validate(); err != nil {
+		return fmt.Errorf("invalid --bytebase-unmatched-files: %w", err)
+	}
+	if err := route.SQLCheckPolicy(bytebaseSQLCheck).Validate(); err != nil {
+		return fmt.Errorf("invalid --bytebase-sql-check: %w", err)
+	}
+	if err := route.VersionOrder(bytebaseVersionOrder).Validate(); err != nil {
+		return fmt.Errorf("invalid --bytebase-version-order: %w", err)
+	}
+	if err := route.VersionFormat(bytebaseVersionFormat).Validate(); err != nil {
+		return fmt.Errorf("invalid --bytebase-version-format: %w", err)
+	}
+	if route.VersionOrder(bytebaseVersionOrder) == route.VersionOrderSequential && route.VersionFormat(bytebaseVersionFormat) != route.VersionFormatNatural {
+		return fmt.Errorf("--bytebase-version-order=%s requires --bytebase-version-format=%s", route.VersionOrderSequential, route.VersionFormatNatural)
+	}
+
 	if bytebaseURL == "" {
 		fmt.Printf("--bytebase-url is missing, Bytebase sinker will not be able to process any events.\n")
 		return nil
@@ -72,7 +137,17 @@ func (sinker *bytebaseSinker) Mount() error {
 		return nil
 	}
 
-	sinker.bytebaseService = service.NewBytebase(bytebaseURL, bytebaseServiceAccount, bytebaseServiceKey)
+	apiVersion := service.BytebaseAPIVersion(bytebaseAPIVersion)
+	switch apiVersion {
+	case service.BytebaseAPIAuto, service.BytebaseAPIV1, service.BytebaseAPILegacy:
+	default:
+		return fmt.Errorf("invalid --bytebase-api-version %q, must be auto, v1 or legacy", bytebaseAPIVersion)
+	}
+	if apiVersion == service.BytebaseAPILegacy && bytebaseCheckExisting {
+		return fmt.Errorf("--bytebase-check-existing is not supported by the legacy API, set --bytebase-check-existing=false to create the issues without looking up the existing versions")
+	}
+
+	sinker.bytebaseService = service.NewBytebase(bytebaseURL, bytebaseServiceAccount, bytebaseServiceKey, apiVersion)
 	return nil
 }
 
@@ -87,16 +162,128 @@ func (sinker *bytebaseSinker) Process(c context.Context, _ string, pi interface{
 		return fmt.Errorf("---bytebase-service-key is required")
 	}
 
-	change := pi.(payload.GerritFileChangeMessage)
+	var routeName string
+	var files []*payload.GerritChangedFile
+	var title string
+	// notify posts the SQL check findings and the outcome back to the originating changes if set.
+	var notify func(findings []*SQLFinding, outcome string)
+	switch change := pi.(type) {
+	case payload.GerritPatchSetCheckMessage:
+		return sinker.review(c, change)
+	case payload.GerritCommandMessage:
+		return sinker.runCommand(c, change)
+	case payload.GerritFileChangeMessage:
+		routeName = change.Route
+		files = change.Files
+		title = "Gerrit direct push"
+		if sinker.gerrit != nil && len(change.Changes) > 0 {
+			notify = func(findings []*SQLFinding, outcome string) {
+				postFindings(c, sinker.gerrit, change, findings, outcome)
+			}
+		}
+		if len(change.Changes) > 0 {
+			var numberList []string
+			for _, number := range change.Changes {
+				numberList = append(numberList, strconv.Itoa(number))
+			}
+			title = fmt.Sprintf("Gerrit change %s", strings.Join(numberList, ", "))
+		}
+	case payload.GitHubFileChangeMessage:
+		if sinker.github != nil {
+			notify = func(findings []*SQLFinding, outcome string) {
+				postCommitFindings(c, sinker.github, change, findings, outcome)
+			}
+		}
+		for _, file := range change.Files {
+			files = append(files, &payload.GerritChangedFile{
+				FileName: file.FileName,
+				Content:  file.Content,
+				Status:   gerritFileStatus(file.Status),
+				OldPath:  file.PreviousFileName,
+			})
+		}
+		title = fmt.Sprintf("GitHub %s %s", change.Repository, change.Ref)
+	default:
+		return fmt.Errorf("unsupported Bytebase payload %T", pi)
+	}
+
+	r, err := sinker.findRoute(routeName)
+	if err != nil {
+		return err
+	}
+	issueCreateList, skipped, err := prepareIssues(r, files)
+	skip(c, skipped...)
+	if err != nil {
+		return err
+	}
+	issueCreateList, skipped, warnings, err := sinker.checkExisting(c, r, newExistingChangeFinder(sinker.bytebaseService), issueCreateList)
+	skip(c, skipped...)
+	warn(c, warnings...)
+	if err != nil {
+		return err
+	}
+	findings, err := sinker.checkSQL(c, r, issueCreateList)
+	addFindings(c, findings...)
+	outcome := "no issue is created"
+	if err == nil {
+		if _, err = sinker.createIssues(c, groupIssues(title, versionComparator(versionFormat(r)), issueCreateList), bytebaseRollout); err != nil {
+			outcome = fmt.Sprintf("no issue is created: %v", err)
+		} else {
+			outcome = "the issues are created"
+		}
+	}
+	if notify != nil && len(findings) > 0 {
+		notify(findings, outcome)
+	}
+	return err
+}
 
-	for _, file := range change.Files {
-		mi, err := parseMigrationInfo(file.FileName, filePathTemplate)
+// prepareIssues validates all files and returns the issues to create for them, and the files skipped.
+// The files not matching the file path template are handled by the unmatched files policy of the route.
+func prepareIssues(r *route.Route, files []*payload.GerritChangedFile) ([]*payload.IssueCreate, []*SkippedFile, error) {
+	var issueCreateList []*payload.IssueCreate
+	var skipped []*SkippedFile
+	var unmatched []string
+	// versions is the file of every version by the database resource name and the version.
+	versions := map[string]string{}
+	for _, file := range files {
+		if file.Status == payload.GerritFileRenamed {
+			skipped = append(skipped, &SkippedFile{
+				FileName: file.FileName,
+				Reason:   fmt.Sprintf("renamed from %q, the migration is not applied again", file.OldPath),
+			})
+			continue
+		}
+		mi, err := parseRoutedMigrationInfo(r, file.FileName)
 		if err != nil {
-			return err
+			return nil, skipped, err
+		}
+		if mi == nil {
+			switch unmatchedFilesPolicy(r) {
+			case route.UnmatchedFilesIgnore:
+			case route.UnmatchedFilesFail:
+				unmatched = append(unmatched, file.FileName)
+			default:
+				skipped = append(skipped, &SkippedFile{
+					FileName: file.FileName,
+					Reason:   fmt.Sprintf("does not match %s", templateDescription(r)),
+				})
+			}
+			continue
+		}
+		if err := checkFileStatus(file, mi); err != nil {
+			return nil, skipped, err
+		}
+		if mi.Version != "" {
+			key := mi.Target + "@" + mi.Version
+			if other, ok := versions[key]; ok {
+				return nil, skipped, fmt.Errorf("files %q and %q have the same version %s of %s", other, file.FileName, mi.Version, mi.Target)
+			}
+			versions[key] = file.FileName
 		}
 
 		issueName := fmt.Sprintf(issueNameTemplate, mi.Name, file.FileName)
-		issueCreate := &payload.IssueCreate{
+		issueCreateList = append(issueCreateList, &payload.IssueCreate{
 			ProjectKey:    mi.Project,
 			Database:      mi.Database,
 			Environment:   mi.Environment,
@@ -105,90 +292,980 @@ func (sinker *bytebaseSinker) Process(c context.Context, _ string, pi interface{
 			MigrationType: mi.Type,
 			Statement:     file.Content,
 			SchemaVersion: mi.Version,
+			Target:        mi.Target,
+			FileName:      file.FileName,
+		})
+	}
+	if len(unmatched) > 0 {
+		return nil, skipped, fmt.Errorf("%d file(s) do not match %s: %s", len(unmatched), templateDescription(r), strings.Join(unmatched, ", "))
+	}
+	return issueCreateList, skipped, nil
+}
+
+// compileDefaultTemplates compiles --bytebase-file-path-template and --bytebase-schema-file-template.
+func compileDefaultTemplates() error {
+	template, err := route.CompileTemplate(bytebaseFilePathTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid --bytebase-file-path-template: %w", err)
+	}
+	if !template.Has(route.PlaceholderEnvironment) {
+		return fmt.Errorf("invalid --bytebase-file-path-template: %q has no {{%s}} to resolve the Bytebase instance", bytebaseFilePathTemplate, route.PlaceholderEnvironment)
+	}
+	defaultTemplate = template
+	defaultSchemaTemplate = nil
+	if bytebaseSchemaFileTemplate != "" {
+		schemaTemplate, err := route.CompileSchemaTemplate(bytebaseSchemaFileTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid --bytebase-schema-file-template: %w", err)
 		}
-		if err := sinker.bytebaseService.CreateIssue(c, issueCreate); err != nil {
-			return err
+		if !schemaTemplate.Has(route.PlaceholderEnvironment) {
+			return fmt.Errorf("invalid --bytebase-schema-file-template: %q has no {{%s}} to resolve the Bytebase instance", bytebaseSchemaFileTemplate, route.PlaceholderEnvironment)
 		}
+		defaultSchemaTemplate = schemaTemplate
 	}
-
 	return nil
 }
 
-// parseMigrationInfo matches filePath against filePathTemplate
-func parseMigrationInfo(filePath, filePathTemplate string) (*migrationInfo, error) {
-	// Escape "." characters to match literals instead of using it as a wildcard.
-	filePathRegex := strings.ReplaceAll(filePathTemplate, `.`, `\.`)
+// checkExisting checks the changes against the versions in Bytebase looked up by the finder, it returns
+// the changes to create, the files skipped since their versions exist, and the warnings, e.g. about the
+// versions out of order. Nothing is checked if --bytebase-check-existing is not set, or with a server
+// detected to support only the legacy API, which cannot look up the versions and is reported as a warning.
+func (sinker *bytebaseSinker) checkExisting(ctx context.Context, r *route.Route, finder *existingChangeFinder, issueCreateList []*payload.IssueCreate) ([]*payload.IssueCreate, []*SkippedFile, []string, error) {
+	if !bytebaseCheckExisting {
+		return issueCreateList, nil, nil, nil
+	}
+	apiVersion, err := sinker.bytebaseService.APIVersion(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// The explicit legacy API is refused on mount, so the server is detected to be before 2.0.
+	if apiVersion == service.BytebaseAPILegacy {
+		return issueCreateList, nil, []string{"the Bytebase server only supports the legacy API which cannot look up the existing versions, the versions are not checked"}, nil
+	}
+
+	remaining, skipped, err := skipExisting(ctx, finder, issueCreateList)
+	if err != nil {
+		return nil, skipped, nil, err
+	}
+	warnings, err := checkVersionOrder(ctx, r, finder, remaining)
+	if err != nil {
+		return nil, skipped, nil, err
+	}
+	return remaining, skipped, warnings, nil
+}
+
+// skipExisting skips the changes whose version is already applied to the database or planned in an
+// issue not canceled, e.g. the event is redelivered, and fails if the statement of any such version
+// differs. The changes without the version are not checked.
+func skipExisting(ctx context.Context, finder *existingChangeFinder, issueCreateList []*payload.IssueCreate) ([]*payload.IssueCreate, []*SkippedFile, error) {
+	var remaining []*payload.IssueCreate
+	var skipped []*SkippedFile
+	var conflicts []string
+	for _, change := range issueCreateList {
+		// The change histories and the plans are looked up by the database, not the database group.
+		if change.SchemaVersion == "" || route.IsDatabaseGroup(change.Target) {
+			remaining = append(remaining, change)
+			continue
+		}
+		existing, statement, err := finder.find(ctx, change)
+		if err != nil {
+			return nil, skipped, fmt.Errorf("failed to look up version %s of %s: %w", change.SchemaVersion, change.Target, err)
+		}
+		switch {
+		case existing == "":
+			remaining = append(remaining, change)
+		case normalizeStatement(statement) == normalizeStatement(change.Statement):
+			skipped = append(skipped, &SkippedFile{
+				FileName: change.FileName,
+				Reason:   fmt.Sprintf("version %s of %s already exists in %s", change.SchemaVersion, change.Target, existing),
+			})
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("%s: version %s of %s already exists in %s with a different statement", change.FileName, change.SchemaVersion, change.Target, existing))
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, skipped, fmt.Errorf("%d file(s) conflict with the existing versions, add a new migration file with a newer version instead: %s", len(conflicts), strings.Join(conflicts, "; "))
+	}
+	return remaining, skipped, nil
+}
 
-	filePathRegex = strings.ReplaceAll(filePathRegex, `/*/`, `/[^/]+/`)
-	filePathRegex = strings.ReplaceAll(filePathRegex, `**`, `.*`)
+// checkVersionOrder checks the versioned migrations are newer than every version applied to their
+// databases or planned by the open issues. The older versions are rejected by the strict and the
+// sequential version orders, or reported as the warnings by the out-of-order version order. The
+// sequential version order also rejects the gaps between the versions. The existing versions invalid
+// in the version format are ignored, e.g. applied out of Relay.
+func checkVersionOrder(ctx context.Context, r *route.Route, finder *existingChangeFinder, issueCreateList []*payload.IssueCreate) ([]string, error) {
+	order, format := versionOrder(r), versionFormat(r)
+	if order == route.VersionOrderSequential && format != route.VersionFormatNatural {
+		return nil, fmt.Errorf("the %s version order requires the %s version format, got %s", order, route.VersionFormatNatural, format)
+	}
+	compare := versionComparator(format)
 
-	for _, placeholder := range placeholderList {
-		filePathRegex = strings.ReplaceAll(filePathRegex, fmt.Sprintf("{{%s}}", placeholder), fmt.Sprintf(`(?P<%s>%s)`, placeholder, placeholderRegexp))
+	// changeMap is the versioned migrations by the database resource name.
+	changeMap := map[string][]*payload.IssueCreate{}
+	var targetList []string
+	for _, change := range issueCreateList {
+		if change.SchemaVersion == "" || change.MigrationType == payload.SDL || route.IsDatabaseGroup(change.Target) {
+			continue
+		}
+		if _, ok := changeMap[change.Target]; !ok {
+			targetList = append(targetList, change.Target)
+		}
+		changeMap[change.Target] = append(changeMap[change.Target], change)
 	}
-	myRegex, err := regexp.Compile(filePathRegex)
+
+	var violations []string
+	for _, target := range targetList {
+		changeList := changeMap[target]
+		versionList, err := finder.listVersions(ctx, changeList[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the versions of %s: %w", target, err)
+		}
+		latest := ""
+		for _, version := range versionList {
+			if validateVersion(format, version) != nil {
+				continue
+			}
+			if order == route.VersionOrderSequential {
+				if _, err := parseSequentialVersion(version); err != nil {
+					continue
+				}
+			}
+			if latest == "" || compare(version, latest) > 0 {
+				latest = version
+			}
+		}
+
+		sort.SliceStable(changeList, func(i, j int) bool {
+			return compare(changeList[i].SchemaVersion, changeList[j].SchemaVersion) < 0
+		})
+		previous := latest
+		for _, change := range changeList {
+			if latest != "" && compare(change.SchemaVersion, latest) <= 0 {
+				violations = append(violations, fmt.Sprintf("%s: version %s of %s is older than the applied or planned version %s", change.FileName, change.SchemaVersion, target, latest))
+				continue
+			}
+			if order == route.VersionOrderSequential {
+				if err := checkNextVersion(previous, change.SchemaVersion); err != nil {
+					violations = append(violations, fmt.Sprintf("%s: %v", change.FileName, err))
+				}
+				previous = change.SchemaVersion
+			}
+		}
+	}
+	if len(violations) == 0 || order == route.VersionOrderOutOfOrder {
+		return violations, nil
+	}
+	return nil, fmt.Errorf("%d file(s) break the %s version order, rename them with the next versions: %s", len(violations), order, strings.Join(violations, "; "))
+}
+
+// existingChangeFinder looks up the changes in Bytebase, the resources listed are cached for an event.
+type existingChangeFinder struct {
+	service *service.BytebaseService
+	// histories is the change histories by the database resource name.
+	histories map[string][]*payload.ChangeHistory
+	// plans is the plans by the project resource name.
+	plans map[string][]*payload.Plan
+	// issues is the issues by the issue resource name.
+	issues map[string]*payload.Issue
+	// open is the open issues found planning the changes by the issue resource name.
+	open map[string]*payload.Issue
+}
+
+func newExistingChangeFinder(s *service.BytebaseService) *existingChangeFinder {
+	return &existingChangeFinder{
+		service:   s,
+		histories: map[string][]*payload.ChangeHistory{},
+		plans:     map[string][]*payload.Plan{},
+		issues:    map[string]*payload.Issue{},
+		open:      map[string]*payload.Issue{},
+	}
+}
+
+// openIssues returns the open issues found planning the changes, sorted by the name.
+func (f *existingChangeFinder) openIssues() []*payload.Issue {
+	var issueList []*payload.Issue
+	for _, issue := range f.open {
+		issueList = append(issueList, issue)
+	}
+	sort.Slice(issueList, func(i, j int) bool {
+		return issueList[i].Name < issueList[j].Name
+	})
+	return issueList
+}
+
+// find returns the resource name holding the version of the change and its statement, or an empty
+// name if none. The change histories applying the version are looked up first, then the plans.
+func (f *existingChangeFinder) find(ctx context.Context, change *payload.IssueCreate) (string, string, error) {
+	historyList, err := f.listHistories(ctx, change.Target)
+	if err != nil {
+		return "", "", err
+	}
+	for _, history := range historyList {
+		// The failed version can be applied again.
+		if history.Version != change.SchemaVersion || history.Status == payload.ChangeHistoryFailed {
+			continue
+		}
+		if history.Issue != "" {
+			return history.Issue, history.Statement, nil
+		}
+		return history.Name, history.Statement, nil
+	}
+
+	planList, err := f.listPlans(ctx, change.ProjectKey)
+	if err != nil {
+		return "", "", err
+	}
+	for _, plan := range planList {
+		// The plan without the issue is left by a failed creation.
+		if plan.Issue == "" {
+			continue
+		}
+		for _, step := range plan.Steps {
+			for _, spec := range step.Specs {
+				config := spec.ChangeDatabaseConfig
+				if config == nil || config.Target != change.Target || config.SchemaVersion != change.SchemaVersion {
+					continue
+				}
+				issue, err := f.getIssue(ctx, plan.Issue)
+				if err != nil {
+					return "", "", err
+				}
+				if issue.Status == payload.IssueStatusCanceled {
+					continue
+				}
+				statement, err := f.service.GetSheetContent(ctx, config.Sheet)
+				if err != nil {
+					return "", "", err
+				}
+				if issue.Status == payload.IssueStatusOpen {
+					if issue.Plan == "" {
+						issue.Plan = plan.Name
+					}
+					f.open[issue.Name] = issue
+				}
+				return plan.Issue, statement, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// listVersions returns the versions of the database of the change applied by the change histories,
+// and planned by the open issues. The failed versions and the SDL changes are excluded.
+func (f *existingChangeFinder) listVersions(ctx context.Context, change *payload.IssueCreate) ([]string, error) {
+	historyList, err := f.listHistories(ctx, change.Target)
+	if err != nil {
+		return nil, err
+	}
+	var versionList []string
+	for _, history := range historyList {
+		if history.Version == "" || history.Status == payload.ChangeHistoryFailed || history.Type == payload.SDL {
+			continue
+		}
+		versionList = append(versionList, history.Version)
+	}
+
+	planList, err := f.listPlans(ctx, change.ProjectKey)
 	if err != nil {
-		return nil, fmt.Errorf("invalid file path template: %q", filePathTemplate)
+		return nil, err
+	}
+	for _, plan := range planList {
+		if plan.Issue == "" {
+			continue
+		}
+		for _, step := range plan.Steps {
+			for _, spec := range step.Specs {
+				config := spec.ChangeDatabaseConfig
+				if config == nil || config.Target != change.Target || config.SchemaVersion == "" || config.Type == payload.SDL {
+					continue
+				}
+				issue, err := f.getIssue(ctx, plan.Issue)
+				if err != nil {
+					return nil, err
+				}
+				// The versions of the done issues are in the change histories.
+				if issue.Status == payload.IssueStatusOpen {
+					versionList = append(versionList, config.SchemaVersion)
+				}
+			}
+		}
+	}
+	return versionList, nil
+}
+
+func (f *existingChangeFinder) listPlans(ctx context.Context, projectKey string) ([]*payload.Plan, error) {
+	project := fmt.Sprintf("projects/%s", projectKey)
+	if planList, ok := f.plans[project]; ok {
+		return planList, nil
+	}
+	planList, err := f.service.ListPlans(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	f.plans[project] = planList
+	return planList, nil
+}
+
+func (f *existingChangeFinder) listHistories(ctx context.Context, database string) ([]*payload.ChangeHistory, error) {
+	if historyList, ok := f.histories[database]; ok {
+		return historyList, nil
+	}
+	historyList, err := f.service.ListChangeHistories(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	f.histories[database] = historyList
+	return historyList, nil
+}
+
+func (f *existingChangeFinder) getIssue(ctx context.Context, name string) (*payload.Issue, error) {
+	if issue, ok := f.issues[name]; ok {
+		return issue, nil
+	}
+	issue, err := f.service.GetIssue(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f.issues[name] = issue
+	return issue, nil
+}
+
+// normalizeStatement normalizes the line endings and the trailing spaces of the statement to compare.
+func normalizeStatement(statement string) string {
+	lines := strings.Split(strings.ReplaceAll(statement, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// unmatchedFilesPolicy returns the unmatched files policy of the route, default to --bytebase-unmatched-files.
+func unmatchedFilesPolicy(r *route.Route) route.UnmatchedFilesPolicy {
+	if r != nil && r.Bytebase.UnmatchedFiles != "" {
+		return r.Bytebase.UnmatchedFiles
+	}
+	return route.UnmatchedFilesPolicy(bytebaseUnmatchedFiles)
+}
+
+// versionOrder returns the version order of the route, default to --bytebase-version-order.
+func versionOrder(r *route.Route) route.VersionOrder {
+	if r != nil && r.Bytebase.VersionOrder != "" {
+		return r.Bytebase.VersionOrder
+	}
+	return route.VersionOrder(bytebaseVersionOrder)
+}
+
+// versionFormat returns the version format of the route, default to --bytebase-version-format.
+func versionFormat(r *route.Route) route.VersionFormat {
+	if r != nil && r.Bytebase.VersionFormat != "" {
+		return r.Bytebase.VersionFormat
+	}
+	return route.VersionFormat(bytebaseVersionFormat)
+}
+
+// routeTemplate returns the file path template of the route, default to --bytebase-file-path-template.
+func routeTemplate(r *route.Route) *route.Template {
+	if r != nil && r.Template() != nil {
+		return r.Template()
+	}
+	return defaultTemplate
+}
+
+// routeSchemaTemplate returns the schema file template of the route, default to --bytebase-schema-file-template.
+// It returns nil if neither is set.
+func routeSchemaTemplate(r *route.Route) *route.Template {
+	if r != nil && r.SchemaTemplate() != nil {
+		return r.SchemaTemplate()
+	}
+	return defaultSchemaTemplate
+}
+
+// templateDescription describes the templates the SQL files of the route are matched against.
+func templateDescription(r *route.Route) string {
+	if schemaTemplate := routeSchemaTemplate(r); schemaTemplate != nil {
+		return fmt.Sprintf("the file path template %q or the schema file template %q", routeTemplate(r), schemaTemplate)
+	}
+	return fmt.Sprintf("the file path template %q", routeTemplate(r))
+}
+
+// groupIssues groups the changes into an issue per Bytebase project, with a step per environment.
+// The changes are ordered by version with compare, the schema files after the versioned migrations,
+// and the projects and the environments are ordered by their first change.
+func groupIssues(title string, compare func(a, b string) int, issueCreateList []*payload.IssueCreate) []*payload.MultiStepIssueCreate {
+	sorted := append([]*payload.IssueCreate{}, issueCreateList...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if isSDL, isOtherSDL := sorted[i].MigrationType == payload.SDL, sorted[j].MigrationType == payload.SDL; isSDL != isOtherSDL {
+			return isOtherSDL
+		}
+		return compare(sorted[i].SchemaVersion, sorted[j].SchemaVersion) < 0
+	})
+
+	var groupList []*payload.MultiStepIssueCreate
+	groups := map[string]*payload.MultiStepIssueCreate{}
+	steps := map[string]*payload.IssueStepCreate{}
+	for _, issueCreate := range sorted {
+		group, ok := groups[issueCreate.ProjectKey]
+		if !ok {
+			group = &payload.MultiStepIssueCreate{
+				ProjectKey: issueCreate.ProjectKey,
+				Title:      fmt.Sprintf("[Relay] %s", title),
+			}
+			groups[issueCreate.ProjectKey] = group
+			groupList = append(groupList, group)
+		}
+		stepKey := issueCreate.ProjectKey + "/" + issueCreate.Environment
+		step, ok := steps[stepKey]
+		if !ok {
+			step = &payload.IssueStepCreate{Title: issueCreate.Environment}
+			steps[stepKey] = step
+			group.Steps = append(group.Steps, step)
+		}
+		step.Changes = append(step.Changes, issueCreate)
 	}
-	if !myRegex.MatchString(filePath) {
-		// File path does not match file path template.
+
+	for _, group := range groupList {
+		var lines []string
+		for _, step := range group.Steps {
+			for _, change := range step.Changes {
+				lines = append(lines, fmt.Sprintf("* %s: %s", change.Name, change.Description))
+			}
+		}
+		group.Description = strings.Join(lines, "\n")
+	}
+	return groupList
+}
+
+// createIssues creates the issues all or nothing, the issues created are canceled if any creation
+// or rollout fails. The issues created with the v1 API are rolled out if rollout is set.
+func (sinker *bytebaseSinker) createIssues(ctx context.Context, groupList []*payload.MultiStepIssueCreate, rollout bool) ([]*payload.Issue, error) {
+	var issueList []*payload.Issue
+	for _, group := range groupList {
+		created, err := sinker.bytebaseService.CreateIssues(ctx, group)
+		issueList = append(issueList, created...)
+		if err != nil {
+			return nil, sinker.cancelIssues(ctx, issueList, fmt.Errorf("failed to create the issue in project %s: %w", group.ProjectKey, err))
+		}
+	}
+
+	if rollout {
+		for _, issue := range issueList {
+			if issue.Plan == "" || issue.Rollout != "" {
+				continue
+			}
+			created, err := sinker.bytebaseService.CreateRollout(ctx, issue.Plan)
+			if err != nil {
+				return nil, sinker.cancelIssues(ctx, issueList, fmt.Errorf("failed to roll out issue %s: %w", issue.Name, err))
+			}
+			issue.Rollout = created.Name
+		}
+	}
+	return issueList, nil
+}
+
+// findRoute returns the route of the Gerrit message by name, nil for the message without a route.
+func (sinker *bytebaseSinker) findRoute(name string) (*route.Route, error) {
+	if name == "" {
 		return nil, nil
 	}
+	if sinker.gerrit == nil {
+		return nil, fmt.Errorf("no Gerrit hooker to find the route %q", name)
+	}
+	r := sinker.gerrit.Route(name)
+	if r == nil {
+		return nil, fmt.Errorf("route %q not found", name)
+	}
+	return r, nil
+}
 
-	mi := &migrationInfo{
-		Type: payload.Migrate,
-	}
-	matchList := myRegex.FindStringSubmatch(filePath)
-	for _, placeholder := range placeholderList {
-		index := myRegex.SubexpIndex(placeholder)
-		if index >= 0 {
-			switch placeholder {
-			case "PROJECT_KEY":
-				mi.Project = matchList[index]
-			case "ENV_NAME":
-				mi.Environment = matchList[index]
-			case "VERSION":
-				mi.Version = matchList[index]
-			case "DB_NAME":
-				mi.Database = matchList[index]
-			case "TYPE":
-				switch matchList[index] {
-				case "data", "dml":
-					mi.Type = payload.Data
-					mi.Type = payload.Data
-					mi.Name = "Change data"
-				case "migrate", "ddl":
-					mi.Type = payload.Migrate
-					mi.Type = payload.Migrate
-					mi.Name = "Alter schema"
-				default:
-					return nil, fmt.Errorf("file path %q contains invalid migration type %q, must be 'migrate'('ddl') or 'data'('dml')", filePath, matchList[index])
+// cancelIssues cancels the issues created before the creation failed with err. The issues created
+// with the legacy API cannot be canceled, and are listed in the error to cancel manually.
+func (sinker *bytebaseSinker) cancelIssues(ctx context.Context, issueList []*payload.Issue, err error) error {
+	var canceled, legacy []string
+	for _, issue := range issueList {
+		if issue.Plan == "" {
+			legacy = append(legacy, issue.Name)
+			continue
+		}
+		canceled = append(canceled, issue.Name)
+	}
+	if len(canceled) > 0 {
+		if cancelErr := sinker.bytebaseService.CancelIssues(ctx, canceled, err.Error()); cancelErr != nil {
+			return fmt.Errorf("%w, and failed to cancel the issues created %s: %v", err, strings.Join(canceled, ", "), cancelErr)
+		}
+	}
+	if len(legacy) > 0 {
+		return fmt.Errorf("%w, cancel the issues created %s manually", err, strings.Join(legacy, ", "))
+	}
+	return err
+}
+
+// review checks the SQL files in the patch set and posts the findings back to the Gerrit change,
+// votes -1 on --bytebase-review-label if there is any error, otherwise +1.
+func (sinker *bytebaseSinker) review(ctx context.Context, check payload.GerritPatchSetCheckMessage) error {
+	if sinker.gerrit == nil {
+		return fmt.Errorf("no Gerrit hooker to post the review on change %s", check.ChangeID)
+	}
+	r, err := sinker.findRoute(check.Route)
+	if err != nil {
+		return err
+	}
+	review := &payload.GerritReviewInput{
+		Comments: map[string][]*payload.GerritCommentInput{},
+	}
+	errorCount, warningCount := 0, 0
+	for _, file := range check.Files {
+		if file.Status == payload.GerritFileRenamed {
+			review.Comments[file.FileName] = append(review.Comments[file.FileName], &payload.GerritCommentInput{
+				Message: fmt.Sprintf("Renamed from %q, the migration will not be applied again.", file.OldPath),
+			})
+			continue
+		}
+		mi, err := parseRoutedMigrationInfo(r, file.FileName)
+		if err == nil && mi != nil {
+			err = checkFileStatus(file, mi)
+		}
+		if err != nil {
+			errorCount++
+			review.Comments[file.FileName] = append(review.Comments[file.FileName], &payload.GerritCommentInput{
+				Message:    err.Error(),
+				Unresolved: true,
+			})
+			continue
+		}
+		if mi == nil {
+			switch unmatchedFilesPolicy(r) {
+			case route.UnmatchedFilesIgnore:
+			case route.UnmatchedFilesFail:
+				errorCount++
+				review.Comments[file.FileName] = append(review.Comments[file.FileName], &payload.GerritCommentInput{
+					Message:    fmt.Sprintf("Does not match %s.", templateDescription(r)),
+					Unresolved: true,
+				})
+			default:
+				review.Comments[file.FileName] = append(review.Comments[file.FileName], &payload.GerritCommentInput{
+					Message: fmt.Sprintf("Does not match %s, the file will be skipped.", routeTemplate(r)),
+				})
+			}
+			continue
+		}
+
+		if route.IsDatabaseGroup(mi.Target) {
+			review.Comments[file.FileName] = append(review.Comments[file.FileName], &payload.GerritCommentInput{
+				Message: fmt.Sprintf("Targets the database group %s, the SQL check is skipped.", mi.Target),
+			})
+			continue
+		}
+		advices, err := sinker.bytebaseService.CheckSQL(ctx, &payload.SQLCheckRequest{
+			Name:      mi.Target,
+			Statement: file.Content,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check %q: %w", file.FileName, err)
+		}
+		for _, advice := range advices {
+			finding := newSQLFinding(file.FileName, advice)
+			if finding == nil {
+				continue
+			}
+			if finding.Status == payload.SQLAdviceError {
+				errorCount++
+			} else {
+				warningCount++
+			}
+			review.Comments[file.FileName] = append(review.Comments[file.FileName], findingComment(finding))
+		}
+	}
+
+	vote := 1
+	if errorCount > 0 {
+		vote = -1
+	}
+	review.Message = fmt.Sprintf("Bytebase SQL check found %d error(s) and %d warning(s).", errorCount, warningCount)
+	review.Labels = map[string]int{bytebaseReviewLabel: vote}
+	return sinker.gerrit.PostReview(ctx, check.ChangeID, check.Revision, review)
+}
+
+// runCommand runs the command commented on the Gerrit change and replies the outcome on the change.
+func (sinker *bytebaseSinker) runCommand(ctx context.Context, cmd payload.GerritCommandMessage) error {
+	if sinker.gerrit == nil {
+		return fmt.Errorf("no Gerrit hooker to reply %s on change %s", cmd.Command, cmd.ChangeID)
+	}
+	reply, err := sinker.command(ctx, cmd)
+	if err != nil {
+		reply = fmt.Sprintf("Relay %s failed: %v", cmd.Command, err)
+	}
+	if postErr := sinker.gerrit.PostReview(ctx, cmd.ChangeID, cmd.Revision, &payload.GerritReviewInput{Message: reply}); postErr != nil {
+		return fmt.Errorf("failed to reply %s outcome on change %s: %w", cmd.Command, cmd.ChangeID, postErr)
+	}
+	return err
+}
+
+func (sinker *bytebaseSinker) command(ctx context.Context, cmd payload.GerritCommandMessage) (string, error) {
+	r, err := sinker.findRoute(cmd.Route)
+	if err != nil {
+		return "", err
+	}
+	issueCreateList, skipped, err := prepareIssues(r, cmd.Files)
+	if err != nil {
+		return "", err
+	}
+	finder := newExistingChangeFinder(sinker.bytebaseService)
+	issueCreateList, existing, warnings, err := sinker.checkExisting(ctx, r, finder, issueCreateList)
+	if err != nil {
+		return "", err
+	}
+	skipped = append(skipped, existing...)
+	findings, err := sinker.checkSQL(ctx, r, issueCreateList)
+	if err != nil {
+		return "", err
+	}
+	reply, err := sinker.commandIssues(ctx, cmd, r, issueCreateList, finder.openIssues())
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString(reply)
+	if len(skipped) > 0 {
+		fmt.Fprintf(&sb, "\n\nSkipped %d file(s):\n", len(skipped))
+		for _, file := range skipped {
+			fmt.Fprintf(&sb, "\n* %s: %s", file.FileName, file.Reason)
+		}
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintf(&sb, "\n\n%d warning(s):\n", len(warnings))
+		for _, warning := range warnings {
+			fmt.Fprintf(&sb, "\n* %s", warning)
+		}
+	}
+	if len(findings) > 0 {
+		fmt.Fprintf(&sb, "\n\nBytebase SQL check found %d finding(s):\n", len(findings))
+		for _, finding := range findings {
+			fmt.Fprintf(&sb, "\n* %s: %s", finding.FileName, finding)
+		}
+	}
+	return sb.String(), nil
+}
+
+// commandIssues runs the command on the issues for the change, the issues to create and the open issues
+// created for the change before, e.g. by the change-merged event.
+func (sinker *bytebaseSinker) commandIssues(ctx context.Context, cmd payload.GerritCommandMessage, r *route.Route, issueCreateList []*payload.IssueCreate, openList []*payload.Issue) (string, error) {
+	if len(issueCreateList) == 0 && len(openList) == 0 {
+		return fmt.Sprintf("Relay %s: no issue to create.", cmd.Command), nil
+	}
+
+	groupList := groupIssues(fmt.Sprintf("Gerrit change %s", cmd.ChangeID), versionComparator(versionFormat(r)), issueCreateList)
+	var sb strings.Builder
+	switch cmd.Command {
+	case payload.GerritCommandDryRun:
+		fmt.Fprintf(&sb, "Relay dry-run: %d issue(s) will be created.\n", len(groupList))
+		for _, group := range groupList {
+			fmt.Fprintf(&sb, "\n* %s in project %s", group.Title, group.ProjectKey)
+			for i, step := range group.Steps {
+				fmt.Fprintf(&sb, "\n  %d. environment %s", i+1, step.Title)
+				for _, change := range step.Changes {
+					fmt.Fprintf(&sb, "\n    - %s: target %s, version %s, type %s", change.Name, change.Target, change.SchemaVersion, change.MigrationType)
 				}
-			case "DESCRIPTION":
-				mi.Description = matchList[index]
 			}
 		}
+		if len(openList) > 0 {
+			if len(groupList) > 0 {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "\n%d open issue(s) exist, rolled out by /relay apply or run again by /relay retry:\n", len(openList))
+			for _, issue := range openList {
+				fmt.Fprintf(&sb, "\n* %s", issue.Name)
+			}
+		}
+	case payload.GerritCommandRetry, payload.GerritCommandApply:
+		apply := cmd.Command == payload.GerritCommandApply
+		var issueList []*payload.Issue
+		if len(groupList) > 0 {
+			var err error
+			if issueList, err = sinker.createIssues(ctx, groupList, bytebaseRollout || apply); err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(&sb, "Relay %s: %d issue(s) created.\n", cmd.Command, len(issueList))
+		for _, issue := range issueList {
+			if !apply {
+				fmt.Fprintf(&sb, "\n* %s", issue.Name)
+				continue
+			}
+			if issue.Plan == "" {
+				return "", fmt.Errorf("issue %s has no plan to roll out, the Bytebase server does not support rollouts", issue.Name)
+			}
+			fmt.Fprintf(&sb, "\n* %s rolled out by %s", issue.Name, issue.Rollout)
+		}
+		if len(openList) > 0 {
+			if len(issueList) > 0 {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "\n%d open issue(s) exist:\n", len(openList))
+			for _, issue := range openList {
+				outcome, err := sinker.resumeIssue(ctx, issue, apply)
+				if err != nil {
+					return "", err
+				}
+				fmt.Fprintf(&sb, "\n* %s %s", issue.Name, outcome)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unsupported command %q", cmd.Command)
+	}
+	return sb.String(), nil
+}
+
+// resumeIssue resumes the open issue created for the change before, and returns the outcome. The issue
+// without the rollout is rolled out by apply, or by retry if --bytebase-rollout is set. The failed and
+// canceled tasks of the rollout are run again by retry.
+func (sinker *bytebaseSinker) resumeIssue(ctx context.Context, issue *payload.Issue, apply bool) (string, error) {
+	if issue.Rollout == "" {
+		if !apply && !bytebaseRollout {
+			return "is not rolled out, run /relay apply to roll it out", nil
+		}
+		rollout, err := sinker.bytebaseService.CreateRollout(ctx, issue.Plan)
+		if err != nil {
+			return "", fmt.Errorf("failed to roll out issue %s: %w", issue.Name, err)
+		}
+		return fmt.Sprintf("rolled out by %s", rollout.Name), nil
+	}
+	if apply {
+		return fmt.Sprintf("already rolled out by %s", issue.Rollout), nil
+	}
+
+	rollout, err := sinker.bytebaseService.GetRollout(ctx, issue.Rollout)
+	if err != nil {
+		return "", fmt.Errorf("failed to get rollout %s: %w", issue.Rollout, err)
+	}
+	count := 0
+	for _, stage := range rollout.Stages {
+		var taskList []string
+		for _, task := range stage.Tasks {
+			if task.Status == payload.RolloutTaskFailed || task.Status == payload.RolloutTaskCanceled {
+				taskList = append(taskList, task.Name)
+			}
+		}
+		if len(taskList) == 0 {
+			continue
+		}
+		if err := sinker.bytebaseService.BatchRunTasks(ctx, stage.Name, taskList, "Relay retry"); err != nil {
+			return "", fmt.Errorf("failed to run the tasks of %s again: %w", stage.Name, err)
+		}
+		count += len(taskList)
 	}
+	if count == 0 {
+		return fmt.Sprintf("has no failed task to run again in %s", issue.Rollout), nil
+	}
+	return fmt.Sprintf("runs %d failed task(s) again in %s", count, issue.Rollout), nil
+}
 
-	if mi.Version == "" {
-		return nil, fmt.Errorf("file path %q does not contain {{VERSION}}, configured file path template %q", filePath, filePathTemplate)
+// checkFileStatus refuses the modification to a versioned migration file, since the version
+// has been applied when the file was added, and the modification would never be applied.
+// The schema files are expected to be modified.
+func checkFileStatus(file *payload.GerritChangedFile, mi *migrationInfo) error {
+	if mi.Type == payload.SDL {
+		return nil
 	}
-	if mi.Database == "" {
-		return nil, fmt.Errorf("file path %q does not contain {{DB_NAME}}, configured file path template %q", filePath, filePathTemplate)
+	switch file.Status {
+	case payload.GerritFileModified, payload.GerritFileRewritten:
+		return fmt.Errorf("file %q modifies the already applied migration version %s, add a new migration file with a newer version instead", file.FileName, mi.Version)
+	case payload.GerritFileAdded, payload.GerritFileCopied:
+		// The copied file is a new migration file, e.g. copied from the directory of another environment.
+		return nil
+	default:
+		return fmt.Errorf("file %q has unsupported status %q", file.FileName, file.Status)
+	}
+}
+
+// gerritFileStatus maps the status of a file changed on GitHub to the Gerrit one, so that the
+// files from both are handled in the same way.
+func gerritFileStatus(status payload.GitHubFileStatus) payload.GerritFileStatus {
+	switch status {
+	case payload.GitHubFileAdded:
+		return payload.GerritFileAdded
+	case payload.GitHubFileModified, payload.GitHubFileChanged:
+		return payload.GerritFileModified
+	case payload.GitHubFileRemoved:
+		return payload.GerritFileDeleted
+	case payload.GitHubFileRenamed:
+		return payload.GerritFileRenamed
+	case payload.GitHubFileCopied:
+		return payload.GerritFileCopied
+	default:
+		return payload.GerritFileStatus(status)
+	}
+}
+
+// databaseResourceName returns the Bytebase database resource name of the database not mapped by
+// the route, the environment ID is used as the instance ID.
+func databaseResourceName(environment, database string) string {
+	return fmt.Sprintf("instances/%s/databases/%s", environment, database)
+}
+
+// parseRoutedMigrationInfo matches filePath against the file path template of the route, then the
+// schema file template, and applies the Bytebase settings of the route, e.g. maps the environment and
+// the database. The route is nil if the change is not routed.
+func parseRoutedMigrationInfo(r *route.Route, filePath string) (*migrationInfo, error) {
+	template := routeTemplate(r)
+	mi, err := parseMigrationInfo(filePath, template)
+	if err != nil {
+		return nil, err
+	}
+	if mi == nil {
+		if template = routeSchemaTemplate(r); template == nil {
+			return nil, nil
+		}
+		if mi = parseSchemaInfo(filePath, template); mi == nil {
+			return nil, nil
+		}
+	}
+	if r != nil && r.Bytebase.ProjectKey != "" {
+		mi.Project = r.Bytebase.ProjectKey
+	}
+	if mi.Project == "" {
+		return nil, fmt.Errorf("file path %q has no {{%s}} and the route sets no project key, configured file path template %q", filePath, route.PlaceholderProjectKey, template)
+	}
+	if r != nil {
+		target, mapped := r.DatabaseTarget(mi.Project, mi.Environment, mi.Database)
+		mi.Environment = r.Environment(mi.Environment)
+		if mapped {
+			mi.Target = target
+			if !route.IsDatabaseGroup(target) {
+				mi.Database = target[strings.LastIndex(target, "/")+1:]
+			}
+		}
+	}
+	if mi.Target == "" {
+		if mi.Environment == "" {
+			return nil, fmt.Errorf("file path %q has no {{%s}} to resolve the Bytebase instance, and the route maps no database %q, configured file path template %q", filePath, route.PlaceholderEnvironment, mi.Database, template)
+		}
+		mi.Target = databaseResourceName(mi.Environment, mi.Database)
+	}
+	if mi.Version != "" {
+		if err := validateVersion(versionFormat(r), mi.Version); err != nil {
+			return nil, fmt.Errorf("file path %q has an invalid version: %w", filePath, err)
+		}
+	}
+	return mi, nil
+}
+
+// parseMigrationInfo matches filePath against the template, it returns nil if the file path does not match.
+func parseMigrationInfo(filePath string, template *route.Template) (*migrationInfo, error) {
+	values, ok := template.Match(filePath)
+	if !ok {
+		return nil, nil
+	}
+
+	mi := &migrationInfo{
+		Type:        payload.Migrate,
+		Name:        "Alter schema",
+		Project:     values[route.PlaceholderProjectKey],
+		Environment: values[route.PlaceholderEnvironment],
+		Database:    values[route.PlaceholderDatabase],
+		Version:     values[route.PlaceholderVersion],
+		Description: values[route.PlaceholderDescription],
+	}
+	if migrationType, ok := values[route.PlaceholderType]; ok {
+		switch migrationType {
+		case "data", "dml":
+			mi.Type = payload.Data
+			mi.Name = "Change data"
+		case "migrate", "ddl":
+			mi.Type = payload.Migrate
+			mi.Name = "Alter schema"
+		case "baseline":
+			mi.Type = payload.Baseline
+			mi.Name = "Establish baseline"
+		default:
+			return nil, fmt.Errorf("file path %q contains invalid migration type %q, must be 'migrate'('ddl'), 'data'('dml') or 'baseline'", filePath, migrationType)
+		}
 	}
 
 	if mi.Description == "" {
 		switch mi.Type {
 		case payload.Data:
 			mi.Description = fmt.Sprintf("Create %s data change", mi.Database)
+		case payload.Baseline:
+			mi.Description = fmt.Sprintf("Establish %s schema baseline", mi.Database)
 		default:
 			mi.Description = fmt.Sprintf("Create %s schema migration", mi.Database)
 		}
 	} else {
-		// Replace _ with space
-		mi.Description = strings.ReplaceAll(mi.Description, "_", " ")
-		// Capitalize first letter
-		mi.Description = strings.ToUpper(mi.Description[:1]) + mi.Description[1:]
+		mi.Description = prettifyDescription(mi.Description)
 	}
 
 	return mi, nil
 }
+
+// parseSchemaInfo matches filePath against the schema file template, it returns nil if the file path
+// does not match. The schema file holds the desired schema of the database, Bytebase computes the
+// statements to migrate the database to it, so the version is optional.
+func parseSchemaInfo(filePath string, template *route.Template) *migrationInfo {
+	values, ok := template.Match(filePath)
+	if !ok {
+		return nil
+	}
+
+	mi := &migrationInfo{
+		Type:        payload.SDL,
+		Name:        "Update schema",
+		Project:     values[route.PlaceholderProjectKey],
+		Environment: values[route.PlaceholderEnvironment],
+		Database:    values[route.PlaceholderDatabase],
+		Version:     values[route.PlaceholderVersion],
+		Description: values[route.PlaceholderDescription],
+	}
+	if mi.Description == "" {
+		mi.Description = fmt.Sprintf("Update %s schema to the desired state", mi.Database)
+	} else {
+		mi.Description = prettifyDescription(mi.Description)
+	}
+	return mi
+}
+
+// prettifyDescription replaces "_" with space and capitalizes the first letter.
+func prettifyDescription(description string) string {
+	description = strings.ReplaceAll(description, "_", " ")
+	return strings.ToUpper(description[:1]) + description[1:]
+}
+
+// ParseFilePaths prints how the file paths map to the Bytebase migrations with the route, or with
+// --bytebase-file-path-template and --bytebase-schema-file-template if the route is nil, and returns
+// an error if any file path is not selected by the route, does not match or is invalid.
+func ParseFilePaths(w io.Writer, r *route.Route, filePathList []string) error {
+	if err := compileDefaultTemplates(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, filePath := range filePathList {
+		var mi *migrationInfo
+		var err error
+		if r != nil && !r.MatchFile(filePath) {
+			err = fmt.Errorf("file path %q is not selected by the files of route %q", filePath, r.Name)
+		} else {
+			mi, err = parseRoutedMigrationInfo(r, filePath)
+			if err == nil && mi == nil {
+				err = fmt.Errorf("file path %q does not match %s", filePath, templateDescription(r))
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\n  error: %v\n", filePath, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", filePath)
+		fmt.Fprintf(w, "  project:     %s\n", mi.Project)
+		fmt.Fprintf(w, "  environment: %s\n", mi.Environment)
+		fmt.Fprintf(w, "  database:    %s\n", mi.Database)
+		fmt.Fprintf(w, "  target:      %s\n", mi.Target)
+		fmt.Fprintf(w, "  version:     %s\n", mi.Version)
+		fmt.Fprintf(w, "  type:        %s\n", mi.Type)
+		fmt.Fprintf(w, "  description: %s\n", mi.Description)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file path(s) failed to parse", failed, len(filePathList))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
+)
+
+// checkSQL runs the Bytebase SQL check on the changes before creating the issues by the SQL check
+// policy of the route. It returns the findings in the order of the changes, and an error listing
+// the errors found if the policy blocks them. With the annotate policy, the findings are appended
+// to the descriptions of the changes. The changes to the database groups are not checked.
+func (sinker *bytebaseSinker) checkSQL(ctx context.Context, r *route.Route, issueCreateList []*payload.IssueCreate) ([]*SQLFinding, error) {
+	policy := sqlCheckPolicy(r)
+	if policy == route.SQLCheckOff {
+		return nil, nil
+	}
+
+	var findings []*SQLFinding
+	var errorLines []string
+	for _, change := range issueCreateList {
+		if route.IsDatabaseGroup(change.Target) {
+			continue
+		}
+		advices, err := sinker.bytebaseService.CheckSQL(ctx, &payload.SQLCheckRequest{
+			Name:      change.Target,
+			Statement: change.Statement,
+		})
+		if err != nil {
+			return findings, fmt.Errorf("failed to check %q: %w", change.FileName, err)
+		}
+		var annotations []string
+		for _, advice := range advices {
+			finding := newSQLFinding(change.FileName, advice)
+			if finding == nil {
+				continue
+			}
+			if finding.Status == payload.SQLAdviceError {
+				errorLines = append(errorLines, fmt.Sprintf("* %s: %s", finding.FileName, finding))
+			}
+			findings = append(findings, finding)
+			annotations = append(annotations, fmt.Sprintf("  - %s", finding))
+		}
+		if policy == route.SQLCheckAnnotate && len(annotations) > 0 {
+			change.Description = fmt.Sprintf("%s\n%s", change.Description, strings.Join(annotations, "\n"))
+		}
+	}
+	if policy == route.SQLCheckBlock && len(errorLines) > 0 {
+		return findings, fmt.Errorf("Bytebase SQL check found %d error(s), no issue is created:\n%s", len(errorLines), strings.Join(errorLines, "\n"))
+	}
+	return findings, nil
+}
+
+// sqlCheckPolicy returns the SQL check policy of the route, default to --bytebase-sql-check.
+func sqlCheckPolicy(r *route.Route) route.SQLCheckPolicy {
+	if r != nil && r.Bytebase.SQLCheck != "" {
+		return r.Bytebase.SQLCheck
+	}
+	return route.SQLCheckPolicy(bytebaseSQLCheck)
+}
+
+// newSQLFinding returns the finding of the advice on the file, or nil if the advice is a success.
+func newSQLFinding(fileName string, advice *payload.SQLAdvice) *SQLFinding {
+	if advice.Status != payload.SQLAdviceError && advice.Status != payload.SQLAdviceWarning {
+		return nil
+	}
+	return &SQLFinding{
+		FileName: fileName,
+		Status:   advice.Status,
+		Title:    advice.Title,
+		Content:  advice.Content,
+		Line:     advice.Line,
+	}
+}
+
+// findingComment returns the Gerrit comment of the finding, the errors are unresolved.
+func findingComment(finding *SQLFinding) *payload.GerritCommentInput {
+	return &payload.GerritCommentInput{
+		Line:       finding.Line,
+		Message:    fmt.Sprintf("[%s] %s: %s", finding.Status, finding.Title, finding.Content),
+		Unresolved: finding.Status == payload.SQLAdviceError,
+	}
+}
+
+// postFindings posts the findings back to the merged changes holding the files, with the outcome of
+// creating the issues. The failures are logged, since the findings are reported in the webhook response
+// as well.
+func postFindings(ctx context.Context, gerrit Gerrit, message payload.GerritFileChangeMessage, findings []*SQLFinding, outcome string) {
+	changes := map[string]int{}
+	for _, file := range message.Files {
+		changes[file.FileName] = file.Change
+	}
+	reviews := map[int]*payload.GerritReviewInput{}
+	errorCounts, warningCounts := map[int]int{}, map[int]int{}
+	for _, finding := range findings {
+		number := changes[finding.FileName]
+		review, ok := reviews[number]
+		if !ok {
+			review = &payload.GerritReviewInput{Comments: map[string][]*payload.GerritCommentInput{}}
+			reviews[number] = review
+		}
+		review.Comments[finding.FileName] = append(review.Comments[finding.FileName], findingComment(finding))
+		if finding.Status == payload.SQLAdviceError {
+			errorCounts[number]++
+		} else {
+			warningCounts[number]++
+		}
+	}
+
+	for _, number := range message.Changes {
+		review, ok := reviews[number]
+		if !ok {
+			continue
+		}
+		review.Message = fmt.Sprintf("Bytebase SQL check found %d error(s) and %d warning(s) on submission, %s.", errorCounts[number], warningCounts[number], outcome)
+		if err := gerrit.PostReview(ctx, strconv.Itoa(number), "current", review); err != nil {
+			fmt.Printf("Failed to post the SQL check findings on change %d: %v\n", number, err)
+		}
+	}
+}
+
+// postCommitFindings posts the findings back to the GitHub commit holding the files as a comment,
+// with the outcome of creating the issues. The failure is logged, since the findings are reported
+// in the webhook response as well.
+func postCommitFindings(ctx context.Context, github GitHub, message payload.GitHubFileChangeMessage, findings []*SQLFinding, outcome string) {
+	errorCount := 0
+	var lines []string
+	for _, finding := range findings {
+		if finding.Status == payload.SQLAdviceError {
+			errorCount++
+		}
+		lines = append(lines, fmt.Sprintf("- `%s` %s", finding.FileName, finding))
+	}
+	comment := &payload.GitHubCommitCommentInput{
+		Body: fmt.Sprintf("Bytebase SQL check found %d error(s) and %d warning(s) in the SQL files, %s.\n\n%s", errorCount, len(findings)-errorCount, outcome, strings.Join(lines, "\n")),
+	}
+	if err := github.CreateCommitComment(ctx, message.Repository, message.Ref, comment); err != nil {
+		fmt.Printf("Failed to post the SQL check findings on commit %s of %s: %v\n", message.Ref, message.Repository, err)
+	}
+}
@@ -0,0 +1,196 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
+)
+
+func TestCheckSQL(t *testing.T) {
+	var checks int32
+	sinker := newTestBytebase(t, map[string]http.HandlerFunc{
+		"/v1/sql/check": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&checks, 1)
+			check := &payload.SQLCheckRequest{}
+			if err := json.NewDecoder(r.Body).Decode(check); err != nil {
+				t.Error(err)
+			}
+			if strings.HasPrefix(check.Statement, "DROP") {
+				_, _ = w.Write([]byte(`{"advices":[{"status":"ERROR","title":"Drop table","content":"Dropping the table is forbidden","line":1}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"advices":[{"status":"SUCCESS"},{"status":"WARNING","title":"Primary key","content":"Table has no primary key","line":1}]}`))
+		},
+	})
+
+	type test struct {
+		policy       route.SQLCheckPolicy
+		wantChecks   int32
+		wantFindings int
+		wantErr      bool
+		wantAnnotate bool
+	}
+
+	tests := []test{
+		{policy: route.SQLCheckOff},
+		{policy: route.SQLCheckReport, wantChecks: 3, wantFindings: 3},
+		{policy: route.SQLCheckAnnotate, wantChecks: 3, wantFindings: 3, wantAnnotate: true},
+		{policy: route.SQLCheckBlock, wantChecks: 3, wantFindings: 3, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			atomic.StoreInt32(&checks, 0)
+			r, err := route.New("default", "db", "main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Bytebase.SQLCheck = tc.policy
+			issueCreateList := []*payload.IssueCreate{
+				{FileName: "create.sql", Description: "Create table", Statement: "CREATE TABLE t (id INT);", Target: "instances/prod/databases/orders"},
+				{FileName: "drop.sql", Description: "Drop table", Statement: "DROP TABLE t;", Target: "instances/prod/databases/orders"},
+				{FileName: "seed.sql", Description: "Seed", Statement: "SELECT 1;", Target: "instances/test/databases/orders"},
+				// The database group is not checked.
+				{FileName: "tenants.sql", Description: "Tenants", Statement: "DROP TABLE t;", Target: "projects/db/databaseGroups/tenants"},
+			}
+
+			findings, err := sinker.checkSQL(context.Background(), r, issueCreateList)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expect error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil && (!strings.Contains(err.Error(), "drop.sql") || strings.Contains(err.Error(), "create.sql")) {
+				t.Errorf("Expect the error to list the errors only, got %v", err)
+			}
+			if got := atomic.LoadInt32(&checks); got != tc.wantChecks {
+				t.Errorf("Expect %d checks, got %d", tc.wantChecks, got)
+			}
+			// The warnings of create.sql and seed.sql, and the error of drop.sql, the successes are not findings.
+			if len(findings) != tc.wantFindings {
+				t.Errorf("Expect %d findings, got %v", tc.wantFindings, findings)
+			}
+			annotated := strings.Contains(issueCreateList[0].Description, "[WARNING] Primary key")
+			if annotated != tc.wantAnnotate {
+				t.Errorf("Expect annotated %v, got %q", tc.wantAnnotate, issueCreateList[0].Description)
+			}
+		})
+	}
+}
+
+func TestPostFindings(t *testing.T) {
+	gerrit := &fakeGerrit{reviews: map[string]*payload.GerritReviewInput{}}
+	message := payload.GerritFileChangeMessage{
+		Changes: []int{12, 13, 14},
+		Files: []*payload.GerritChangedFile{
+			{FileName: "create.sql", Change: 12},
+			{FileName: "drop.sql", Change: 13},
+			{FileName: "seed.sql", Change: 14},
+		},
+	}
+	postFindings(context.Background(), gerrit, message, []*SQLFinding{
+		{FileName: "create.sql", Status: payload.SQLAdviceWarning, Title: "Primary key", Line: 1},
+		{FileName: "drop.sql", Status: payload.SQLAdviceError, Title: "Drop table", Line: 1},
+	}, "no issue is created")
+
+	if len(gerrit.reviews) != 2 {
+		t.Fatalf("Expect the reviews on the changes with the findings, got %v", gerrit.reviews)
+	}
+	review := gerrit.reviews["13/current"]
+	if review == nil || review.Message != "Bytebase SQL check found 1 error(s) and 0 warning(s) on submission, no issue is created." {
+		t.Fatalf("Unexpected review %+v", review)
+	}
+	if comments := review.Comments["drop.sql"]; len(comments) != 1 || !comments[0].Unresolved {
+		t.Errorf("Expect an unresolved comment on drop.sql, got %v", comments)
+	}
+}
+
+func TestProcessPostFindings(t *testing.T) {
+	setDefaultTemplates(t, route.DefaultFilePathTemplate, "")
+	account, key, sqlCheck := bytebaseServiceAccount, bytebaseServiceKey, bytebaseSQLCheck
+	t.Cleanup(func() {
+		bytebaseServiceAccount, bytebaseServiceKey, bytebaseSQLCheck = account, key, sqlCheck
+	})
+	bytebaseServiceAccount, bytebaseServiceKey, bytebaseSQLCheck = "relay@service.bytebase.com", "secret", string(route.SQLCheckReport)
+
+	var failed bool
+	sinker := newTestBytebase(t, map[string]http.HandlerFunc{
+		"/v1/sql/check": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"advices":[{"status":"WARNING","title":"Primary key","content":"Table has no primary key","line":1}]}`))
+		},
+		"/v1/instances/": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{}`))
+		},
+		"/v1/projects/": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			collection, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/projects/db/"), "/")
+			switch {
+			case failed && collection == "issues":
+				http.Error(w, "issue failed", http.StatusInternalServerError)
+			case collection == "issues":
+				_, _ = w.Write([]byte(`{"name":"projects/db/issues/1","plan":"projects/db/plans/1"}`))
+			default:
+				fmt.Fprintf(w, `{"name":"projects/db/%s/1"}`, collection)
+			}
+		},
+	})
+	gerrit := &fakeGerrit{reviews: map[string]*payload.GerritReviewInput{}}
+	sinker.gerrit = gerrit
+	message := payload.GerritFileChangeMessage{
+		Changes: []int{12},
+		Files: []*payload.GerritChangedFile{
+			{FileName: "db/prod/orders##001##ddl##create.sql", Status: payload.GerritFileAdded, Content: "CREATE TABLE t (id INT);", Change: 12},
+		},
+	}
+
+	if err := sinker.Process(context.Background(), "", message); err != nil {
+		t.Fatal(err)
+	}
+	if review := gerrit.reviews["12/current"]; review == nil || !strings.HasSuffix(review.Message, "on submission, the issues are created.") {
+		t.Errorf("Expect the findings posted after the issues are created, got %+v", review)
+	}
+
+	failed = true
+	if err := sinker.Process(context.Background(), "", message); err == nil {
+		t.Fatal("Expect the issue creation error")
+	}
+	if review := gerrit.reviews["12/current"]; review == nil || !strings.Contains(review.Message, "no issue is created: failed to create the issue in project db") {
+		t.Errorf("Expect the findings posted with the creation failure, got %+v", review)
+	}
+
+	// The findings of the GitHub files are posted as a comment on the commit.
+	failed = false
+	github := &fakeGitHub{}
+	sinker.github = github
+	err := sinker.Process(context.Background(), "", payload.GitHubFileChangeMessage{
+		Repository: "bytebase/db",
+		Ref:        "0123456",
+		Files: []*payload.GitHubChangedFile{
+			{FileName: "db/prod/orders##001##ddl##create.sql", Status: payload.GitHubFileAdded, Content: "CREATE TABLE t (id INT);"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "bytebase/db@0123456: Bytebase SQL check found 0 error(s) and 1 warning(s) in the SQL files, the issues are created.\n\n- `db/prod/orders##001##ddl##create.sql` [WARNING] Primary key: Table has no primary key (line 1)"
+	if len(github.comments) != 1 || github.comments[0] != want {
+		t.Errorf("Expect the findings posted on the commit, got %q", github.comments)
+	}
+}
+
+type fakeGitHub struct {
+	comments []string
+}
+
+func (g *fakeGitHub) CreateCommitComment(_ context.Context, repo, sha string, comment *payload.GitHubCommitCommentInput) error {
+	g.comments = append(g.comments, fmt.Sprintf("%s@%s: %s", repo, sha, comment.Body))
+	return nil
+}
@@ -0,0 +1,644 @@
+package sink
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bytebase/relay/payload"
+	"github.com/bytebase/relay/route"
+	"github.com/bytebase/relay/service"
+)
+
+type fakeGerrit struct {
+	routes  []*route.Route
+	reviews map[string]*payload.GerritReviewInput
+}
+
+func (r *fakeGerrit) Route(name string) *route.Route {
+	for _, rt := range r.routes {
+		if rt.Name == name {
+			return rt
+		}
+	}
+	return nil
+}
+
+func (r *fakeGerrit) PostReview(_ context.Context, changeID, revisionID string, review *payload.GerritReviewInput) error {
+	r.reviews[changeID+"/"+revisionID] = review
+	return nil
+}
+
+// newTestBytebase returns the sinker talking to a fake Bytebase server, which serves the handlers by
+// the path pattern, and the login and the server version of the v1 API unless in the handlers.
+func newTestBytebase(t *testing.T, handlers map[string]http.HandlerFunc) *bytebaseSinker {
+	mux := http.NewServeMux()
+	if _, ok := handlers["/v1/auth/login"]; !ok {
+		mux.HandleFunc("/v1/auth/login", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"token":"token"}`))
+		})
+	}
+	if _, ok := handlers["/v1/actuator/info"]; !ok {
+		mux.HandleFunc("/v1/actuator/info", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"version":"2.10.0"}`))
+		})
+	}
+	for pattern, handler := range handlers {
+		mux.HandleFunc(pattern, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &bytebaseSinker{bytebaseService: service.NewBytebase(server.URL, "relay@service.bytebase.com", "secret", service.BytebaseAPIAuto)}
+}
+
+// setDefaultTemplates compiles the default templates for the test, and restores them after the test.
+// The schema file template is not set if empty.
+func setDefaultTemplates(t *testing.T, filePathTemplate, schemaFileTemplate string) {
+	template, schemaTemplate := defaultTemplate, defaultSchemaTemplate
+	t.Cleanup(func() {
+		defaultTemplate, defaultSchemaTemplate = template, schemaTemplate
+	})
+
+	var err error
+	if defaultTemplate, err = route.CompileTemplate(filePathTemplate); err != nil {
+		t.Fatal(err)
+	}
+	defaultSchemaTemplate = nil
+	if schemaFileTemplate != "" {
+		if defaultSchemaTemplate, err = route.CompileSchemaTemplate(schemaFileTemplate); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrepareIssuesUnmatchedFiles(t *testing.T) {
+	setDefaultTemplates(t, route.DefaultFilePathTemplate, "")
+
+	files := []*payload.GerritChangedFile{
+		{FileName: "db/prod/orders##001##ddl##create_table.sql", Status: payload.GerritFileAdded, Content: "CREATE TABLE t (id INT);"},
+		{FileName: "docs/example.sql", Status: payload.GerritFileAdded},
+		{FileName: "db/prod/orders##000##ddl.sql", Status: payload.GerritFileRenamed, OldPath: "db/prod/orders##000##ddl##init.sql"},
+	}
+
+	type test struct {
+		policy      route.UnmatchedFilesPolicy
+		wantSkipped int
+		wantErr     bool
+	}
+
+	tests := []test{
+		{policy: route.UnmatchedFilesIgnore, wantSkipped: 1},
+		{policy: route.UnmatchedFilesWarn, wantSkipped: 2},
+		{policy: route.UnmatchedFilesFail, wantSkipped: 1, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			r, err := route.New("default", "db", "main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Bytebase.UnmatchedFiles = tc.policy
+
+			issueCreateList, skipped, err := prepareIssues(r, files)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expect error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "docs/example.sql") {
+				t.Errorf("Expect the error to list the unmatched file, got %v", err)
+			}
+			if len(skipped) != tc.wantSkipped {
+				t.Errorf("Expect %d skipped files, got %d", tc.wantSkipped, len(skipped))
+			}
+			if !tc.wantErr && len(issueCreateList) != 1 {
+				t.Errorf("Expect 1 issue, got %d", len(issueCreateList))
+			}
+		})
+	}
+}
+
+func TestPrepareIssuesSchemaFiles(t *testing.T) {
+	setDefaultTemplates(t, route.DefaultFilePathTemplate, "{{PROJECT_KEY}}/{{ENV_NAME}}/schema/{{DB_NAME}}.sql")
+
+	files := []*payload.GerritChangedFile{
+		{FileName: "db/prod/schema/orders.sql", Status: payload.GerritFileModified, Content: "CREATE TABLE t (id INT, name TEXT);"},
+		{FileName: "db/prod/orders##002##data##seed.sql", Status: payload.GerritFileAdded, Content: "INSERT INTO t VALUES (1);"},
+		{FileName: "db/prod/orders##001##baseline##init.sql", Status: payload.GerritFileAdded, Content: "CREATE TABLE t (id INT);"},
+	}
+	issueCreateList, skipped, err := prepareIssues(nil, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("Expect no skipped file, got %v", skipped)
+	}
+
+	groupList := groupIssues("test", compareVersions, issueCreateList)
+	if len(groupList) != 1 || len(groupList[0].Steps) != 1 {
+		t.Fatalf("Expect 1 issue with 1 step, got %v", groupList)
+	}
+	var got []payload.MigrationType
+	for _, change := range groupList[0].Steps[0].Changes {
+		got = append(got, change.MigrationType)
+	}
+	// The schema file is applied after the versioned migrations.
+	want := []payload.MigrationType{payload.Baseline, payload.Data, payload.SDL}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expect %v, got %v", want, got)
+	}
+}
+
+func TestGroupIssuesSchemaFilesSemver(t *testing.T) {
+	issueCreateList := []*payload.IssueCreate{
+		{ProjectKey: "db", Environment: "prod", Name: "orders.sql", MigrationType: payload.SDL},
+		{ProjectKey: "db", Environment: "prod", Name: "seed", SchemaVersion: "1.10.0", MigrationType: payload.Data},
+		{ProjectKey: "db", Environment: "prod", Name: "users.sql", MigrationType: payload.SDL},
+		{ProjectKey: "db", Environment: "prod", Name: "init", SchemaVersion: "v1.9.0", MigrationType: payload.Baseline},
+	}
+	// The schema files have no version, which must not break the semver comparison.
+	groupList := groupIssues("test", versionComparator(route.VersionFormatSemver), issueCreateList)
+	if len(groupList) != 1 || len(groupList[0].Steps) != 1 {
+		t.Fatalf("Expect 1 issue with 1 step, got %v", groupList)
+	}
+	var got []string
+	for _, change := range groupList[0].Steps[0].Changes {
+		got = append(got, change.Name)
+	}
+	if want := "[init seed orders.sql users.sql]"; fmt.Sprint(got) != want {
+		t.Errorf("Expect %s, got %v", want, got)
+	}
+}
+
+func TestSkipExisting(t *testing.T) {
+	sinker := newTestBytebase(t, map[string]http.HandlerFunc{
+		"/v1/instances/prod/databases/orders/changeHistories": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"changeHistories":[
+				{"name":"instances/prod/databases/orders/changeHistories/1","status":"DONE","version":"001","statement":"CREATE TABLE t (id INT);\r\n","issue":"projects/db/issues/1"},
+				{"name":"instances/prod/databases/orders/changeHistories/2","status":"FAILED","version":"004","statement":"DROP TABLE t;"}
+			]}`))
+		},
+		"/v1/projects/db/plans": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"plans":[
+				{"name":"projects/db/plans/2","issue":"projects/db/issues/2","steps":[{"specs":[{"changeDatabaseConfig":{"target":"instances/prod/databases/orders","sheet":"projects/db/sheets/2","schemaVersion":"002"}}]}]},
+				{"name":"projects/db/plans/3","issue":"projects/db/issues/3","steps":[{"specs":[{"changeDatabaseConfig":{"target":"instances/prod/databases/orders","sheet":"projects/db/sheets/3","schemaVersion":"003"}}]}]}
+			]}`))
+		},
+		"/v1/projects/db/issues/2": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"name":"projects/db/issues/2","status":"OPEN"}`))
+		},
+		"/v1/projects/db/issues/3": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"name":"projects/db/issues/3","status":"CANCELED"}`))
+		},
+		"/v1/projects/db/sheets/2": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"name":"projects/db/sheets/2","content":%q}`, base64.StdEncoding.EncodeToString([]byte("ALTER TABLE t ADD name TEXT;")))
+		},
+	})
+
+	change := func(version, statement string) *payload.IssueCreate {
+		return &payload.IssueCreate{
+			ProjectKey:    "db",
+			Statement:     statement,
+			SchemaVersion: version,
+			Target:        "instances/prod/databases/orders",
+			FileName:      fmt.Sprintf("db/prod/orders##%s##ddl##change.sql", version),
+		}
+	}
+	finder := newExistingChangeFinder(sinker.bytebaseService)
+	remaining, skipped, warnings, err := sinker.checkExisting(context.Background(), nil, finder, []*payload.IssueCreate{
+		change("001", "CREATE TABLE t (id INT);"),
+		change("002", "ALTER TABLE t ADD name TEXT;"),
+		// The issue of 003 is canceled, and 004 failed.
+		change("003", "CREATE INDEX i ON t (id);"),
+		change("004", "DROP TABLE t;"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 2 || !strings.Contains(skipped[0].Reason, "projects/db/issues/1") || !strings.Contains(skipped[1].Reason, "projects/db/issues/2") {
+		t.Errorf("Expect 001 and 002 skipped, got %v", skipped)
+	}
+	if len(remaining) != 2 || remaining[0].SchemaVersion != "003" || remaining[1].SchemaVersion != "004" {
+		t.Errorf("Expect 003 and 004 to create, got %v", remaining)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expect no warning, got %v", warnings)
+	}
+	if openList := finder.openIssues(); len(openList) != 1 || openList[0].Name != "projects/db/issues/2" || openList[0].Plan != "projects/db/plans/2" {
+		t.Errorf("Expect the open issue of 002, got %v", openList)
+	}
+
+	_, _, _, err = sinker.checkExisting(context.Background(), nil, newExistingChangeFinder(sinker.bytebaseService), []*payload.IssueCreate{change("002", "ALTER TABLE t ADD email TEXT;")})
+	if err == nil || !strings.Contains(err.Error(), "different statement") {
+		t.Errorf("Expect the conflict error, got %v", err)
+	}
+}
+
+func TestCheckExistingLegacy(t *testing.T) {
+	sinker := newTestBytebase(t, map[string]http.HandlerFunc{
+		"/v1/actuator/info": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"version":"1.20.0"}`))
+		},
+	})
+	issueCreateList := []*payload.IssueCreate{{ProjectKey: "db", SchemaVersion: "001", Target: "instances/prod/databases/orders"}}
+	// The server detected to support only the legacy API is not checked, and reported as a warning.
+	remaining, _, warnings, err := sinker.checkExisting(context.Background(), nil, newExistingChangeFinder(sinker.bytebaseService), issueCreateList)
+	if err != nil || len(remaining) != 1 {
+		t.Fatalf("Expect the changes not checked, got %v, %v", remaining, err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "legacy API") {
+		t.Errorf("Expect the warning for the legacy API, got %v", warnings)
+	}
+
+	checkExisting := bytebaseCheckExisting
+	bytebaseCheckExisting = false
+	t.Cleanup(func() { bytebaseCheckExisting = checkExisting })
+	remaining, _, warnings, err = sinker.checkExisting(context.Background(), nil, newExistingChangeFinder(sinker.bytebaseService), issueCreateList)
+	if err != nil || len(remaining) != 1 || len(warnings) != 0 {
+		t.Errorf("Expect the changes not checked without a warning, got %v, %v, %v", remaining, warnings, err)
+	}
+}
+
+func TestMountLegacyCheckExisting(t *testing.T) {
+	setDefaultTemplates(t, route.DefaultFilePathTemplate, "")
+	account, key, apiVersion, checkExisting := bytebaseServiceAccount, bytebaseServiceKey, bytebaseAPIVersion, bytebaseCheckExisting
+	t.Cleanup(func() {
+		bytebaseServiceAccount, bytebaseServiceKey, bytebaseAPIVersion, bytebaseCheckExisting = account, key, apiVersion, checkExisting
+	})
+	bytebaseServiceAccount, bytebaseServiceKey = "relay@service.bytebase.com", "secret"
+
+	// Only the explicit legacy API is refused, the server detected by auto is checked per event.
+	bytebaseAPIVersion, bytebaseCheckExisting = string(service.BytebaseAPILegacy), true
+	if err := NewBytebase(nil, nil).Mount(); err == nil || !strings.Contains(err.Error(), "--bytebase-check-existing") {
+		t.Errorf("Expect the legacy API rejected with --bytebase-check-existing, got %v", err)
+	}
+	bytebaseCheckExisting = false
+	if err := NewBytebase(nil, nil).Mount(); err != nil {
+		t.Errorf("Expect the legacy API without --bytebase-check-existing, got %v", err)
+	}
+	bytebaseAPIVersion, bytebaseCheckExisting = string(service.BytebaseAPIAuto), true
+	if err := NewBytebase(nil, nil).Mount(); err != nil {
+		t.Errorf("Expect the auto API with --bytebase-check-existing, got %v", err)
+	}
+}
+
+func TestCheckVersionOrder(t *testing.T) {
+	orders := "instances/prod/databases/orders"
+	finder := newExistingChangeFinder(nil)
+	finder.histories[orders] = []*payload.ChangeHistory{
+		{Version: "001", Status: payload.ChangeHistoryDone},
+		{Version: "005", Status: payload.ChangeHistoryDone},
+		{Version: "009", Status: payload.ChangeHistoryFailed},
+		{Version: "20230101000000", Status: payload.ChangeHistoryDone, Type: payload.SDL},
+	}
+	plan := func(issue, version string) *payload.Plan {
+		return &payload.Plan{Issue: issue, Steps: []*payload.PlanStep{{Specs: []*payload.PlanSpec{{
+			ChangeDatabaseConfig: &payload.ChangeDatabaseConfig{Target: orders, Type: payload.Migrate, SchemaVersion: version},
+		}}}}}
+	}
+	// The version planned by the open issue counts as applied, not the one of the canceled issue.
+	finder.plans["projects/shop"] = []*payload.Plan{plan("projects/shop/issues/1", "006"), plan("projects/shop/issues/2", "010")}
+	finder.issues["projects/shop/issues/1"] = &payload.Issue{Name: "projects/shop/issues/1", Status: payload.IssueStatusOpen}
+	finder.issues["projects/shop/issues/2"] = &payload.Issue{Name: "projects/shop/issues/2", Status: payload.IssueStatusCanceled}
+
+	change := func(version string) *payload.IssueCreate {
+		return &payload.IssueCreate{SchemaVersion: version, Target: orders, ProjectKey: "shop", FileName: fmt.Sprintf("orders##%s.sql", version)}
+	}
+
+	type test struct {
+		order        route.VersionOrder
+		format       route.VersionFormat
+		versions     []string
+		wantErr      []string
+		wantWarnings []string
+	}
+
+	tests := []test{
+		{order: route.VersionOrderStrict, versions: []string{"003", "009"}, wantErr: []string{"orders##003.sql: version 003 of instances/prod/databases/orders is older than the applied or planned version 006"}},
+		{order: route.VersionOrderStrict, versions: []string{"007", "009"}},
+		{order: route.VersionOrderOutOfOrder, versions: []string{"003", "007"}, wantWarnings: []string{"orders##003.sql: version 003 of instances/prod/databases/orders is older than the applied or planned version 006"}},
+		{order: route.VersionOrderSequential, versions: []string{"008", "007"}},
+		{order: route.VersionOrderSequential, versions: []string{"007", "009"}, wantErr: []string{"orders##009.sql: version 009 is not next to the version 007"}},
+		{order: route.VersionOrderSequential, versions: []string{"008"}, wantErr: []string{"orders##008.sql: version 008 is not next to the version 006"}},
+		{order: route.VersionOrderSequential, versions: []string{"003", "7a"}, wantErr: []string{"orders##003.sql", "orders##7a.sql"}},
+		{order: route.VersionOrderSequential, format: route.VersionFormatSemver, versions: []string{"007"}, wantErr: []string{"requires the natural version format"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s %s %v", tc.order, tc.format, tc.versions), func(t *testing.T) {
+			r, err := route.New("default", "db", "main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Bytebase.VersionOrder, r.Bytebase.VersionFormat = tc.order, tc.format
+			var issueCreateList []*payload.IssueCreate
+			for _, version := range tc.versions {
+				issueCreateList = append(issueCreateList, change(version))
+			}
+
+			warnings, err := checkVersionOrder(context.Background(), r, finder, issueCreateList)
+			if len(tc.wantErr) == 0 && err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("Expect %q in the error, got %v", want, err)
+				}
+			}
+			if fmt.Sprint(warnings) != fmt.Sprint(tc.wantWarnings) {
+				t.Errorf("Expect the warnings %v, got %v", tc.wantWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestFindRoute(t *testing.T) {
+	r, err := route.New("orders", "db/orders", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinker := &bytebaseSinker{gerrit: &fakeGerrit{routes: []*route.Route{r}}}
+
+	got, err := sinker.findRoute("orders")
+	if err != nil || got != r {
+		t.Errorf("Expect route orders, got %v, %v", got, err)
+	}
+	if got, err := sinker.findRoute(""); err != nil || got != nil {
+		t.Errorf("Expect no route for the message without a route, got %v, %v", got, err)
+	}
+	if _, err := sinker.findRoute("users"); err == nil {
+		t.Error("Expect error for the unknown route")
+	}
+	if _, err := (&bytebaseSinker{}).findRoute("orders"); err == nil {
+		t.Error("Expect error without the Gerrit hooker")
+	}
+}
+
+func TestCheckFileStatus(t *testing.T) {
+	type test struct {
+		status  payload.GerritFileStatus
+		wantErr bool
+	}
+
+	tests := []test{
+		{status: payload.GerritFileAdded},
+		{status: payload.GerritFileCopied},
+		{status: payload.GerritFileModified, wantErr: true},
+		{status: payload.GerritFileRewritten, wantErr: true},
+		// The GitHub file statuses are mapped to the Gerrit ones.
+		{status: gerritFileStatus(payload.GitHubFileAdded)},
+		{status: gerritFileStatus(payload.GitHubFileCopied)},
+		{status: gerritFileStatus(payload.GitHubFileModified), wantErr: true},
+		{status: gerritFileStatus(payload.GitHubFileChanged), wantErr: true},
+		{status: gerritFileStatus("unknown"), wantErr: true},
+		{status: "", wantErr: true},
+	}
+
+	mi := &migrationInfo{Version: "001"}
+	for _, tc := range tests {
+		t.Run(string(tc.status), func(t *testing.T) {
+			err := checkFileStatus(&payload.GerritChangedFile{FileName: "prod/orders##001##ddl##init.sql", Status: tc.status}, mi)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Expect error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if got := gerritFileStatus(payload.GitHubFileRenamed); got != payload.GerritFileRenamed {
+		t.Errorf("Expect %q for the renamed GitHub file, got %q", payload.GerritFileRenamed, got)
+	}
+}
+
+func TestParseRoutedMigrationInfo(t *testing.T) {
+	setDefaultTemplates(t, "{{PROJECT_KEY}}/{{DB_NAME}}##{{VERSION}}[##{{ENV_NAME}}].sql", "")
+
+	// Without {{ENV_NAME}} the instance of the database cannot be resolved.
+	if _, err := parseRoutedMigrationInfo(nil, "shop/orders##001.sql"); err == nil {
+		t.Error("Expect error for the file path without the environment")
+	}
+
+	mi, err := parseRoutedMigrationInfo(nil, "shop/orders##001##prod.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mi.Target; got != "instances/prod/databases/orders" {
+		t.Errorf("Expect instances/prod/databases/orders, got %q", got)
+	}
+}
+
+func TestCreateIssuesRolloutFailure(t *testing.T) {
+	var canceled *payload.IssueStatusUpdate
+	sinker := newTestBytebase(t, map[string]http.HandlerFunc{
+		"/v1/projects/": func(w http.ResponseWriter, r *http.Request) {
+			project, collection, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/projects/"), "/")
+			switch collection {
+			case "sheets", "plans":
+				fmt.Fprintf(w, `{"name":"projects/%s/%s/1"}`, project, collection)
+			case "issues":
+				fmt.Fprintf(w, `{"name":"projects/%s/issues/1","plan":"projects/%s/plans/1"}`, project, project)
+			case "rollouts":
+				if project == "users" {
+					http.Error(w, "rollout failed", http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintf(w, `{"name":"projects/%s/rollouts/1"}`, project)
+			default:
+				http.NotFound(w, r)
+			}
+		},
+		"/v1/projects/-/issues:batchUpdateStatus": func(w http.ResponseWriter, r *http.Request) {
+			canceled = &payload.IssueStatusUpdate{}
+			if err := json.NewDecoder(r.Body).Decode(canceled); err != nil {
+				t.Error(err)
+			}
+			_, _ = w.Write([]byte(`{}`))
+		},
+	})
+
+	group := func(project string) *payload.MultiStepIssueCreate {
+		return &payload.MultiStepIssueCreate{
+			ProjectKey: project,
+			Title:      project,
+			Steps: []*payload.IssueStepCreate{{Title: "prod", Changes: []*payload.IssueCreate{
+				{ProjectKey: project, Name: "init", Statement: "CREATE TABLE t (id INT);", Target: "instances/prod/databases/" + project},
+			}}},
+		}
+	}
+	issueList, err := sinker.createIssues(context.Background(), []*payload.MultiStepIssueCreate{group("orders"), group("users")}, true)
+	if err == nil || !strings.Contains(err.Error(), "failed to roll out issue projects/users/issues/1") {
+		t.Fatalf("Expect the rollout error, got %v", err)
+	}
+	if issueList != nil {
+		t.Errorf("Expect no issue returned, got %v", issueList)
+	}
+	if canceled == nil || canceled.Status != payload.IssueStatusCanceled || fmt.Sprint(canceled.Issues) != "[projects/orders/issues/1 projects/users/issues/1]" {
+		t.Errorf("Expect both issues canceled, got %+v", canceled)
+	}
+}
+
+func TestCommandIssuesExisting(t *testing.T) {
+	var rollouts []string
+	var rerun *payload.BatchRunTasksRequest
+	sinker := newTestBytebase(t, map[string]http.HandlerFunc{
+		"/v1/projects/db/rollouts": func(w http.ResponseWriter, r *http.Request) {
+			create := &payload.RolloutCreate{}
+			if err := json.NewDecoder(r.Body).Decode(create); err != nil {
+				t.Error(err)
+			}
+			rollouts = append(rollouts, create.Plan)
+			_, _ = w.Write([]byte(`{"name":"projects/db/rollouts/1"}`))
+		},
+		"/v1/projects/db/rollouts/2": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"name":"projects/db/rollouts/2","stages":[
+				{"name":"projects/db/rollouts/2/stages/test","tasks":[{"name":"projects/db/rollouts/2/stages/test/tasks/1","status":"DONE"}]},
+				{"name":"projects/db/rollouts/2/stages/prod","tasks":[
+					{"name":"projects/db/rollouts/2/stages/prod/tasks/2","status":"FAILED"},
+					{"name":"projects/db/rollouts/2/stages/prod/tasks/3","status":"CANCELED"},
+					{"name":"projects/db/rollouts/2/stages/prod/tasks/4","status":"RUNNING"}
+				]}
+			]}`))
+		},
+		"/v1/projects/db/rollouts/2/stages/prod/tasks:batchRun": func(w http.ResponseWriter, r *http.Request) {
+			rerun = &payload.BatchRunTasksRequest{}
+			if err := json.NewDecoder(r.Body).Decode(rerun); err != nil {
+				t.Error(err)
+			}
+			_, _ = w.Write([]byte(`{}`))
+		},
+	})
+	openList := func() []*payload.Issue {
+		return []*payload.Issue{
+			{Name: "projects/db/issues/1", Plan: "projects/db/plans/1", Status: payload.IssueStatusOpen},
+			{Name: "projects/db/issues/2", Plan: "projects/db/plans/2", Rollout: "projects/db/rollouts/2", Status: payload.IssueStatusOpen},
+		}
+	}
+
+	reply, err := sinker.commandIssues(context.Background(), payload.GerritCommandMessage{Command: payload.GerritCommandApply, ChangeID: "I1"}, nil, nil, openList())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(rollouts) != "[projects/db/plans/1]" {
+		t.Errorf("Expect the rollout of the issue not rolled out, got %v", rollouts)
+	}
+	if !strings.Contains(reply, "projects/db/issues/1 rolled out by projects/db/rollouts/1") || !strings.Contains(reply, "projects/db/issues/2 already rolled out by projects/db/rollouts/2") {
+		t.Errorf("Unexpected apply reply %q", reply)
+	}
+	if rerun != nil {
+		t.Errorf("Expect no task run again on apply, got %+v", rerun)
+	}
+
+	rollouts = nil
+	rollout := bytebaseRollout
+	bytebaseRollout = false
+	t.Cleanup(func() { bytebaseRollout = rollout })
+	reply, err = sinker.commandIssues(context.Background(), payload.GerritCommandMessage{Command: payload.GerritCommandRetry, ChangeID: "I1"}, nil, nil, openList())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rollout is created by retry only with --bytebase-rollout.
+	if len(rollouts) != 0 || !strings.Contains(reply, "projects/db/issues/1 is not rolled out") {
+		t.Errorf("Expect no rollout created on retry, got %v, %q", rollouts, reply)
+	}
+	if rerun == nil || fmt.Sprint(rerun.Tasks) != "[projects/db/rollouts/2/stages/prod/tasks/2 projects/db/rollouts/2/stages/prod/tasks/3]" {
+		t.Errorf("Expect the failed and canceled tasks run again, got %+v", rerun)
+	}
+	if !strings.Contains(reply, "projects/db/issues/2 runs 2 failed task(s) again in projects/db/rollouts/2") {
+		t.Errorf("Unexpected retry reply %q", reply)
+	}
+}
+
+func TestParseFilePaths(t *testing.T) {
+	setDefaultTemplates(t, route.DefaultFilePathTemplate, "")
+	filePath := filepath.Join(t.TempDir(), "routes.json")
+	content := `[{"name": "flyway", "projects": ["db/orders"], "branches": ["main"], "bytebase": {"projectKey": "orders", "filePathTemplate": "{{ENV_NAME}}/{{DB_NAME}}/V{{VERSION}}__{{DESCRIPTION}}.sql"}}]`
+	if err := os.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := route.Load(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := routes[0]
+
+	var sb strings.Builder
+	if err := ParseFilePaths(&sb, r, []string{"prod/orders/V1__init.sql"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "project:     orders") || !strings.Contains(sb.String(), "version:     1\n") {
+		t.Errorf("Expect the file path parsed with the route template, got %s", sb.String())
+	}
+	if err := ParseFilePaths(&strings.Builder{}, r, []string{"docs/init.md"}); err == nil {
+		t.Error("Expect error for the file not selected by the route")
+	}
+	// The default template does not match the file path.
+	if err := ParseFilePaths(&strings.Builder{}, nil, []string{"prod/orders/V1__init.sql"}); err == nil {
+		t.Error("Expect error without the route")
+	}
+}
+
+func TestParseRoutedMigrationInfoMapping(t *testing.T) {
+	setDefaultTemplates(t, route.DefaultFilePathTemplate, "")
+
+	r, err := route.New("default", "db", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Bytebase.Environments = map[string]string{"production": "prod"}
+	r.Bytebase.Databases = map[string]string{
+		"orders":  "instances/mysql-{{ENV_NAME}}/databases/orders_db",
+		"tenants": "projects/{{PROJECT_KEY}}/databaseGroups/tenants",
+	}
+
+	type test struct {
+		filePath        string
+		wantEnvironment string
+		wantDatabase    string
+		wantTarget      string
+	}
+
+	tests := []test{
+		{filePath: "db/production/orders##001##ddl##init.sql", wantEnvironment: "prod", wantDatabase: "orders_db", wantTarget: "instances/mysql-production/databases/orders_db"},
+		{filePath: "db/production/tenants##001##ddl##init.sql", wantEnvironment: "prod", wantDatabase: "tenants", wantTarget: "projects/db/databaseGroups/tenants"},
+		// The database not mapped uses the environment ID as the instance ID.
+		{filePath: "db/production/users##001##ddl##init.sql", wantEnvironment: "prod", wantDatabase: "users", wantTarget: "instances/prod/databases/users"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.filePath, func(t *testing.T) {
+			mi, err := parseRoutedMigrationInfo(r, tc.filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mi.Environment != tc.wantEnvironment || mi.Database != tc.wantDatabase || mi.Target != tc.wantTarget {
+				t.Errorf("Expect %s, %s, %s, got %s, %s, %s", tc.wantEnvironment, tc.wantDatabase, tc.wantTarget, mi.Environment, mi.Database, mi.Target)
+			}
+		})
+	}
+
+	// The databases mapped resolve the Bytebase instances without {{ENV_NAME}} in the file path.
+	filePath := filepath.Join(t.TempDir(), "routes.json")
+	content := `[{"name": "shop", "projects": ["db"], "branches": ["main"], "bytebase": {"filePathTemplate": "{{PROJECT_KEY}}/{{DB_NAME}}##{{VERSION}}.sql", "databases": {"orders": "instances/prod/databases/orders_db"}}}]`
+	if err := os.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := route.Load(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = routes[0]
+	mi, err := parseRoutedMigrationInfo(r, "shop/orders##001.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.Target != "instances/prod/databases/orders_db" {
+		t.Errorf("Expect instances/prod/databases/orders_db, got %q", mi.Target)
+	}
+	if _, err := parseRoutedMigrationInfo(r, "shop/users##001.sql"); err == nil {
+		t.Error("Expect error for the database not mapped")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	flag "github.com/spf13/pflag"
@@ -49,18 +50,9 @@ func (sinker *larkSinker) Process(c context.Context, path string, pi interface{}
 	}
 	switch path {
 	case "/github":
-		p := pi.(payload.GitHubPushEvent)
-		var text string
-		if p.Deleted {
-			text = fmt.Sprintf("%q has been deleted by %s", p.Ref, p.Sender.Login)
-		} else {
-			text = fmt.Sprintf(`New commits have been pushed to %q by %s(%s) at %s
-Title: %s
-Diff: %s`,
-				p.Ref, p.HeadCommit.Author.Name, p.HeadCommit.Author.Email, p.HeadCommit.Timestamp,
-				p.HeadCommit.Message,
-				p.Compare,
-			)
+		text, err := githubText(pi)
+		if err != nil {
+			return err
 		}
 		urlList := strings.Split(webhookURLs, ",")
 		for _, url := range urlList {
@@ -73,6 +65,148 @@ Diff: %s`,
 	return nil
 }
 
+// githubText renders the GitHub event extracted by the GitHub hooker as a Lark text message.
+func githubText(pi interface{}) (string, error) {
+	switch p := pi.(type) {
+	case payload.GitHubPushEvent:
+		if p.Deleted {
+			return fmt.Sprintf("%q has been deleted by %s", p.Ref, p.Sender.Login), nil
+		}
+		if strings.HasPrefix(p.Ref, "refs/tags/") {
+			return fmt.Sprintf(`Tag %q has been pushed by %s
+Commit: %s
+Link: %s`,
+				strings.TrimPrefix(p.Ref, "refs/tags/"), p.Sender.Login,
+				p.HeadCommit.Message,
+				p.HeadCommit.URL,
+			), nil
+		}
+		var headline string
+		switch {
+		case p.Created && p.BaseRef != "":
+			headline = fmt.Sprintf("Branch %q has been created from %q by %s", p.Ref, p.BaseRef, p.Sender.Login)
+		case p.Created:
+			headline = fmt.Sprintf("Branch %q has been created by %s", p.Ref, p.Sender.Login)
+		case p.Forced:
+			headline = fmt.Sprintf("%q has been force-pushed by %s", p.Ref, p.Sender.Login)
+		default:
+			headline = fmt.Sprintf("New commits have been pushed to %q by %s(%s) at %s", p.Ref, p.HeadCommit.Author.Name, p.HeadCommit.Author.Email, p.HeadCommit.Timestamp)
+		}
+		if p.Repository.FullName != "" {
+			headline = fmt.Sprintf("[%s] %s", p.Repository.FullName, headline)
+		}
+		var commits strings.Builder
+		for _, commit := range p.Commits {
+			title := strings.SplitN(commit.Message, "\n", 2)[0]
+			fmt.Fprintf(&commits, "\n- %s %s (%s)", shortSHA(commit.ID), title, commit.Author.Name)
+		}
+		return fmt.Sprintf(`%s
+Title: %s
+Commits(%d):%s
+Diff: %s`,
+			headline,
+			p.HeadCommit.Message,
+			len(p.Commits), commits.String(),
+			p.Compare,
+		), nil
+	case payload.GitHubReleaseEvent:
+		kind := "Release"
+		if p.Release.Prerelease {
+			kind = "Pre-release"
+		}
+		name := p.Release.Name
+		if name == "" {
+			name = p.Release.TagName
+		}
+		return fmt.Sprintf(`[%s] %s %s has been published by %s
+Tag: %s
+Notes: %s
+Link: %s`,
+			p.Repository.FullName, kind, name, p.Release.Author.Login,
+			p.Release.TagName,
+			p.Release.Body,
+			p.Release.HTMLURL,
+		), nil
+	case payload.GitHubWorkflowRunEvent:
+		run := p.WorkflowRun
+		return fmt.Sprintf(`[%s] Workflow %q #%d %s
+Branch: %s
+Commit: %s
+Duration: %s
+Link: %s`,
+			p.Repository.FullName, run.Name, run.RunNumber, conclusionText(run.Conclusion),
+			run.HeadBranch,
+			shortSHA(run.HeadSHA),
+			durationText(run.RunStartedAt, run.UpdatedAt),
+			run.HTMLURL,
+		), nil
+	case payload.GitHubCheckSuiteEvent:
+		suite := p.CheckSuite
+		return fmt.Sprintf(`[%s] Check suite %q %s
+Branch: %s
+Commit: %s
+Duration: %s
+Link: %s/commit/%s/checks`,
+			p.Repository.FullName, suite.App.Name, conclusionText(suite.Conclusion),
+			suite.HeadBranch,
+			shortSHA(suite.HeadSHA),
+			durationText(suite.CreatedAt, suite.UpdatedAt),
+			p.Repository.HTMLURL, suite.HeadSHA,
+		), nil
+	case payload.GitHubDeploymentStatusEvent:
+		status := p.DeploymentStatus
+		link := status.TargetURL
+		if status.LogURL != "" {
+			link = status.LogURL
+		}
+		return fmt.Sprintf(`[%s] Deployment to %q %s
+Ref: %s
+Commit: %s
+Duration: %s
+Description: %s
+Link: %s`,
+			p.Repository.FullName, status.Environment, conclusionText(status.State),
+			p.Deployment.Ref,
+			shortSHA(p.Deployment.SHA),
+			durationText(p.Deployment.CreatedAt, status.CreatedAt),
+			status.Description,
+			link,
+		), nil
+	}
+	return "", errors.Errorf("unsupported GitHub payload %T", pi)
+}
+
+// conclusionText renders the CI conclusion, e.g. "success" becomes "SUCCESS ✅".
+func conclusionText(conclusion string) string {
+	switch conclusion {
+	case "success":
+		return "SUCCESS ✅"
+	case "failure", "error", "timed_out", "startup_failure":
+		return strings.ToUpper(conclusion) + " ❌"
+	}
+	return strings.ToUpper(conclusion)
+}
+
+// durationText renders the duration between two RFC 3339 timestamps, or "unknown" if any is malformed.
+func durationText(start, end string) string {
+	s, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return "unknown"
+	}
+	e, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return "unknown"
+	}
+	return e.Sub(s).String()
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
 type larkPayloadContent struct {
 	Text string `json:"text"`
 }
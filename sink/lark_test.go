@@ -0,0 +1,163 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bytebase/relay/payload"
+)
+
+func TestGitHubText(t *testing.T) {
+	type test struct {
+		name  string
+		event interface{}
+		want  []string
+	}
+
+	repository := payload.GitHubRepository{FullName: "bytebase/relay", HTMLURL: "https://github.com/bytebase/relay"}
+	sender := payload.GitHubUser{Login: "octocat"}
+	commits := []payload.GitHubCommit{
+		{ID: "0123456789abcdef", Message: "Add users table\n\nWith the email column.", Author: payload.GitHubAuthor{Name: "Octo Cat"}},
+	}
+	tests := []test{
+		{
+			name: "push",
+			event: payload.GitHubPushEvent{
+				Ref:        "refs/heads/main",
+				Compare:    "https://github.com/bytebase/relay/compare/a...b",
+				Commits:    commits,
+				HeadCommit: payload.GitHubCommit{Message: "Add users table", Timestamp: "2023-01-02T03:04:05Z", Author: payload.GitHubAuthor{Name: "Octo Cat", Email: "octocat@github.com"}},
+				Repository: repository,
+				Sender:     sender,
+			},
+			want: []string{
+				`[bytebase/relay] New commits have been pushed to "refs/heads/main" by Octo Cat(octocat@github.com) at 2023-01-02T03:04:05Z`,
+				"Commits(1):\n- 0123456 Add users table (Octo Cat)\n",
+				"Diff: https://github.com/bytebase/relay/compare/a...b",
+			},
+		},
+		{
+			name:  "created push",
+			event: payload.GitHubPushEvent{Ref: "refs/heads/feature", Created: true, Repository: repository, Sender: sender},
+			want:  []string{`[bytebase/relay] Branch "refs/heads/feature" has been created by octocat`, "Commits(0):"},
+		},
+		{
+			name:  "created push from a base ref",
+			event: payload.GitHubPushEvent{Ref: "refs/heads/feature", BaseRef: "refs/heads/main", Created: true, Commits: commits, Repository: repository, Sender: sender},
+			want:  []string{`Branch "refs/heads/feature" has been created from "refs/heads/main" by octocat`, "Commits(1):"},
+		},
+		{
+			name:  "forced push",
+			event: payload.GitHubPushEvent{Ref: "refs/heads/main", Forced: true, Repository: repository, Sender: sender},
+			want:  []string{`[bytebase/relay] "refs/heads/main" has been force-pushed by octocat`},
+		},
+		{
+			name:  "tag push",
+			event: payload.GitHubPushEvent{Ref: "refs/tags/v1.2.0", Created: true, HeadCommit: payload.GitHubCommit{Message: "Release 1.2.0", URL: "https://github.com/bytebase/relay/commit/b"}, Sender: sender},
+			want:  []string{`Tag "v1.2.0" has been pushed by octocat`, "Commit: Release 1.2.0", "Link: https://github.com/bytebase/relay/commit/b"},
+		},
+		{
+			name:  "deleted push",
+			event: payload.GitHubPushEvent{Ref: "refs/heads/feature", Deleted: true, Sender: sender},
+			want:  []string{`"refs/heads/feature" has been deleted by octocat`},
+		},
+		{
+			name: "release",
+			event: payload.GitHubReleaseEvent{
+				Action:     payload.GitHubReleasePublished,
+				Release:    payload.GitHubRelease{TagName: "v1.2.0", Name: "Relay 1.2", Body: "Fixes", HTMLURL: "https://github.com/bytebase/relay/releases/v1.2.0", Author: sender},
+				Repository: repository,
+			},
+			want: []string{"[bytebase/relay] Release Relay 1.2 has been published by octocat", "Tag: v1.2.0", "Notes: Fixes"},
+		},
+		{
+			name: "pre-release without a name",
+			event: payload.GitHubReleaseEvent{
+				Action:     payload.GitHubReleasePublished,
+				Release:    payload.GitHubRelease{TagName: "v1.3.0-rc.1", Prerelease: true, Author: sender},
+				Repository: repository,
+			},
+			want: []string{"[bytebase/relay] Pre-release v1.3.0-rc.1 has been published by octocat"},
+		},
+		{
+			name: "workflow run",
+			event: payload.GitHubWorkflowRunEvent{
+				WorkflowRun: payload.GitHubWorkflowRun{Name: "CI", RunNumber: 42, Conclusion: "failure", HeadBranch: "main", HeadSHA: "0123456789abcdef", RunStartedAt: "2023-01-02T03:04:05Z", UpdatedAt: "2023-01-02T03:06:35Z", HTMLURL: "https://github.com/bytebase/relay/actions/runs/1"},
+				Repository:  repository,
+			},
+			want: []string{`[bytebase/relay] Workflow "CI" #42 FAILURE ❌`, "Branch: main", "Commit: 0123456", "Duration: 2m30s", "Link: https://github.com/bytebase/relay/actions/runs/1"},
+		},
+		{
+			name: "check suite",
+			event: payload.GitHubCheckSuiteEvent{
+				CheckSuite: payload.GitHubCheckSuite{App: payload.GitHubApp{Name: "Buildkite"}, Conclusion: "success", HeadBranch: "main", HeadSHA: "0123456789abcdef", CreatedAt: "2023-01-02T03:04:05Z", UpdatedAt: "2023-01-02T03:04:15Z"},
+				Repository: repository,
+			},
+			want: []string{`[bytebase/relay] Check suite "Buildkite" SUCCESS ✅`, "Duration: 10s", "Link: https://github.com/bytebase/relay/commit/0123456789abcdef/checks"},
+		},
+		{
+			name: "deployment status",
+			event: payload.GitHubDeploymentStatusEvent{
+				Deployment:       payload.GitHubDeployment{Ref: "v1.2.0", SHA: "0123456789abcdef", CreatedAt: "2023-01-02T03:04:05Z"},
+				DeploymentStatus: payload.GitHubDeploymentStatus{State: "error", Environment: "prod", CreatedAt: "malformed", TargetURL: "https://example.com/target", LogURL: "https://example.com/log"},
+				Repository:       repository,
+			},
+			want: []string{`[bytebase/relay] Deployment to "prod" ERROR ❌`, "Ref: v1.2.0", "Duration: unknown", "Link: https://example.com/log"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text, err := githubText(tc.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("Expect %q in %q", want, text)
+				}
+			}
+		})
+	}
+
+	if _, err := githubText(payload.GerritFileChangeMessage{}); err == nil {
+		t.Error("Expect error for the unsupported payload")
+	}
+}
+
+func TestConclusionText(t *testing.T) {
+	for conclusion, want := range map[string]string{
+		"success":   "SUCCESS ✅",
+		"failure":   "FAILURE ❌",
+		"timed_out": "TIMED_OUT ❌",
+		"cancelled": "CANCELLED",
+		"":          "",
+	} {
+		if got := conclusionText(conclusion); got != want {
+			t.Errorf("Expect %q for %q, got %q", want, conclusion, got)
+		}
+	}
+}
+
+func TestDurationText(t *testing.T) {
+	type test struct {
+		start, end string
+		want       string
+	}
+
+	tests := []test{
+		{start: "2023-01-02T03:04:05Z", end: "2023-01-02T04:05:06Z", want: "1h1m1s"},
+		{start: "2023-01-02T03:04:05+08:00", end: "2023-01-01T19:04:35Z", want: "30s"},
+		{start: "", end: "2023-01-02T03:04:05Z", want: "unknown"},
+		{start: "2023-01-02T03:04:05Z", end: "2023-01-02 03:04:05", want: "unknown"},
+		{start: "yesterday", end: "today", want: "unknown"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.start+" "+tc.end, func(t *testing.T) {
+			if got := durationText(tc.start, tc.end); got != tc.want {
+				t.Errorf("Expect %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
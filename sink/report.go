@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/bytebase/relay/payload"
+)
+
+// Report is the structured outcome of the sinks processing a payload, e.g. the files skipped.
+// It is returned in the webhook response and logged.
+type Report struct {
+	mu       sync.Mutex
+	Skipped  []*SkippedFile `json:"skipped,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+	Findings []*SQLFinding  `json:"findings,omitempty"`
+}
+
+// SkippedFile is a file skipped by a sink and the reason.
+type SkippedFile struct {
+	FileName string `json:"fileName"`
+	Reason   string `json:"reason"`
+}
+
+// SQLFinding is an error or a warning found by the Bytebase SQL check in a file.
+type SQLFinding struct {
+	FileName string                  `json:"fileName"`
+	Status   payload.SQLAdviceStatus `json:"status"`
+	Title    string                  `json:"title"`
+	Content  string                  `json:"content"`
+	Line     int                     `json:"line,omitempty"`
+}
+
+// String returns the finding in a line.
+func (f *SQLFinding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("[%s] %s: %s (line %d)", f.Status, f.Title, f.Content, f.Line)
+	}
+	return fmt.Sprintf("[%s] %s: %s", f.Status, f.Title, f.Content)
+}
+
+type reportKey struct{}
+
+// WithReport returns the context carrying a new report for the sinks to fill in.
+func WithReport(ctx context.Context) (context.Context, *Report) {
+	r := &Report{}
+	return context.WithValue(ctx, reportKey{}, r), r
+}
+
+// reportFrom returns the report in the context, or nil if none.
+func reportFrom(ctx context.Context) *Report {
+	r, _ := ctx.Value(reportKey{}).(*Report)
+	return r
+}
+
+// skip adds the skipped files to the report in the context, it is a no-op without a report.
+func skip(ctx context.Context, skipped ...*SkippedFile) {
+	r := reportFrom(ctx)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, skipped...)
+}
+
+// warn adds the warnings to the report in the context, it is a no-op without a report.
+func warn(ctx context.Context, warnings ...string) {
+	r := reportFrom(ctx)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, warnings...)
+}
+
+// addFindings adds the SQL check findings to the report in the context, it is a no-op without a report.
+func addFindings(ctx context.Context, findings ...*SQLFinding) {
+	r := reportFrom(ctx)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Findings = append(r.Findings, findings...)
+}
+
+// Empty reports whether nothing is reported.
+func (r *Report) Empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Skipped) == 0 && len(r.Warnings) == 0 && len(r.Findings) == 0
+}
+
+// String returns the JSON encoded report.
+func (r *Report) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
@@ -0,0 +1,184 @@
+package sink
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytebase/relay/route"
+)
+
+var (
+	// semverRegexp matches the semantic version with an optional "v" prefix, see https://semver.org.
+	semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+	// timestampLayouts is the layouts of the timestamp versions, from the most precise.
+	timestampLayouts = []string{"20060102150405", "200601021504", "20060102"}
+)
+
+// validateVersion returns an error if the version is invalid in the format.
+func validateVersion(format route.VersionFormat, version string) error {
+	switch format {
+	case route.VersionFormatSemver:
+		if !semverRegexp.MatchString(version) {
+			return fmt.Errorf("version %q is not a semantic version like 1.2.0", version)
+		}
+	case route.VersionFormatTimestamp:
+		if _, ok := parseTimestampVersion(version); !ok {
+			return fmt.Errorf("version %q is not a timestamp like 20230102150405", version)
+		}
+	}
+	return nil
+}
+
+// versionComparator returns the function comparing the versions valid in the format.
+func versionComparator(format route.VersionFormat) func(a, b string) int {
+	switch format {
+	case route.VersionFormatSemver:
+		return compareSemvers
+	case route.VersionFormatTimestamp:
+		return compareTimestampVersions
+	default:
+		return compareVersions
+	}
+}
+
+// compareVersions compares the versions by their digit runs numerically and other runs lexically,
+// e.g. "2" < "10" and "1.2" < "1.10".
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		var x, y string
+		x, a = nextVersionRun(a)
+		y, b = nextVersionRun(b)
+		if isDigit(x[0]) && isDigit(y[0]) {
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				if len(x) < len(y) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// nextVersionRun splits the leading run of digits or non-digits from the version.
+func nextVersionRun(version string) (string, string) {
+	i := 1
+	for i < len(version) && isDigit(version[i]) == isDigit(version[0]) {
+		i++
+	}
+	return version[:i], version[i:]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// compareSemvers compares the semantic versions by the precedence, the build metadata is ignored.
+// The versions not in the semantic version format, e.g. the empty versions of the schema files,
+// are compared as the natural versions.
+func compareSemvers(a, b string) int {
+	x, y := semverRegexp.FindStringSubmatch(a), semverRegexp.FindStringSubmatch(b)
+	if x == nil || y == nil {
+		return compareVersions(a, b)
+	}
+	for i := 1; i <= 3; i++ {
+		if c := compareVersions(x[i], y[i]); c != 0 {
+			return c
+		}
+	}
+	// A pre-release version has a lower precedence than the normal version.
+	switch {
+	case x[4] == y[4]:
+		return 0
+	case x[4] == "":
+		return 1
+	case y[4] == "":
+		return -1
+	}
+	xs, ys := strings.Split(x[4], "."), strings.Split(y[4], ".")
+	for i := 0; i < len(xs) && i < len(ys); i++ {
+		xn, xErr := strconv.ParseUint(xs[i], 10, 64)
+		yn, yErr := strconv.ParseUint(ys[i], 10, 64)
+		switch {
+		case xErr == nil && yErr == nil:
+			if xn != yn {
+				if xn < yn {
+					return -1
+				}
+				return 1
+			}
+			continue
+		// The numeric identifiers have a lower precedence than the alphanumeric ones.
+		case xErr == nil:
+			return -1
+		case yErr == nil:
+			return 1
+		}
+		if c := strings.Compare(xs[i], ys[i]); c != 0 {
+			return c
+		}
+	}
+	return len(xs) - len(ys)
+}
+
+// compareTimestampVersions compares the timestamp versions by the time.
+func compareTimestampVersions(a, b string) int {
+	x, _ := parseTimestampVersion(a)
+	y, _ := parseTimestampVersion(b)
+	switch {
+	case x.Before(y):
+		return -1
+	case x.After(y):
+		return 1
+	}
+	return 0
+}
+
+func parseTimestampVersion(version string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if len(version) != len(layout) {
+			continue
+		}
+		if t, err := time.Parse(layout, version); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseSequentialVersion parses the natural version of digits only, e.g. 001, for the sequential
+// version order.
+func parseSequentialVersion(version string) (uint64, error) {
+	n, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("version %q is not a number like 001 required by the sequential version order", version)
+	}
+	return n, nil
+}
+
+// checkNextVersion returns an error if the version is not next to the previous version, e.g. 003
+// after 001. Any version is next to the empty previous version.
+func checkNextVersion(previous, version string) error {
+	n, err := parseSequentialVersion(version)
+	if err != nil {
+		return err
+	}
+	if previous == "" {
+		return nil
+	}
+	p, err := parseSequentialVersion(previous)
+	if err != nil {
+		return err
+	}
+	if n != p+1 {
+		return fmt.Errorf("version %s is not next to the version %s", version, previous)
+	}
+	return nil
+}
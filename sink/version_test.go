@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/bytebase/relay/route"
+)
+
+func TestCompareVersions(t *testing.T) {
+	type test struct {
+		format route.VersionFormat
+		a, b   string
+		want   int
+	}
+
+	tests := []test{
+		{format: route.VersionFormatNatural, a: "2", b: "10", want: -1},
+		{format: route.VersionFormatNatural, a: "1.2", b: "1.10", want: -1},
+		{format: route.VersionFormatNatural, a: "001", b: "1", want: 0},
+		{format: route.VersionFormatSemver, a: "1.10.0", b: "v1.9.0", want: 1},
+		{format: route.VersionFormatSemver, a: "1.0.0-rc.1", b: "1.0.0", want: -1},
+		{format: route.VersionFormatSemver, a: "1.0.0-alpha", b: "1.0.0-alpha.1", want: -1},
+		{format: route.VersionFormatSemver, a: "1.0.0-alpha.1", b: "1.0.0-alpha.beta", want: -1},
+		{format: route.VersionFormatSemver, a: "1.0.0-rc.2", b: "1.0.0-rc.10", want: -1},
+		{format: route.VersionFormatSemver, a: "1.0.0+build.1", b: "1.0.0+build.2", want: 0},
+		{format: route.VersionFormatTimestamp, a: "20230102", b: "20230101235959", want: 1},
+		{format: route.VersionFormatTimestamp, a: "202301020000", b: "20230102", want: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.a+" "+tc.b, func(t *testing.T) {
+			for _, version := range []string{tc.a, tc.b} {
+				if err := validateVersion(tc.format, version); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := versionComparator(tc.format)(tc.a, tc.b)
+			if got < 0 {
+				got = -1
+			} else if got > 0 {
+				got = 1
+			}
+			if got != tc.want {
+				t.Errorf("Expect %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInvalidVersion(t *testing.T) {
+	for format, versions := range map[route.VersionFormat][]string{
+		route.VersionFormatSemver:    {"1.2", "01.2.3", "1.2.3-", "latest"},
+		route.VersionFormatTimestamp: {"2023", "20231301", "20230102-1", "1.2.3"},
+	} {
+		for _, version := range versions {
+			if err := validateVersion(format, version); err == nil {
+				t.Errorf("Expect %q invalid as %s", version, format)
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[string, int](2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	// Touch "a" so that "b" becomes the least recently used.
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Expect a=1, got %d(%v)", v, ok)
+	}
+	c.Add("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expect b to be evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("Expect c=3, got %d(%v)", v, ok)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expect 2 entries, got %d", c.Len())
+	}
+
+	c.Add("a", 10)
+	if v, _ := c.Get("a"); v != 10 {
+		t.Errorf("Expect a=10, got %d", v)
+	}
+}
+
+func TestLRUZeroSize(t *testing.T) {
+	c := NewLRU[string, int](0)
+	c.Add("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expect nothing cached")
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF32LE = []byte{0xFF, 0xFE, 0x00, 0x00}
+	bomUTF32BE = []byte{0x00, 0x00, 0xFE, 0xFF}
+)
+
+// DecodeText decodes the text file content to UTF-8 without the byte order mark. The UTF-8 and UTF-16
+// content is detected by the byte order mark, and the UTF-16 content without the byte order mark is
+// detected by the NUL bytes of the ASCII characters. Other encodings are rejected.
+func DecodeText(b []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(b, bomUTF32LE), bytes.HasPrefix(b, bomUTF32BE):
+		return "", fmt.Errorf("UTF-32 is not supported, convert the file to UTF-8")
+	case bytes.HasPrefix(b, bomUTF8):
+		b = b[len(bomUTF8):]
+	case bytes.HasPrefix(b, bomUTF16LE):
+		return decodeUTF16(b[len(bomUTF16LE):], binary.LittleEndian)
+	case bytes.HasPrefix(b, bomUTF16BE):
+		return decodeUTF16(b[len(bomUTF16BE):], binary.BigEndian)
+	case bytes.IndexByte(b, 0) >= 0:
+		if order := guessUTF16(b); order != nil {
+			return decodeUTF16(b, order)
+		}
+		return "", fmt.Errorf("contains NUL bytes, the file is binary or in an unsupported encoding")
+	}
+
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("invalid UTF-8, convert the file to UTF-8")
+	}
+	return string(b), nil
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) (string, error) {
+	if len(b)%2 != 0 {
+		return "", fmt.Errorf("invalid UTF-16, odd number of bytes")
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// guessUTF16 returns the byte order if all the NUL bytes are at either the odd or the even offsets,
+// which is the case for the UTF-16 encoded ASCII text, or nil otherwise.
+func guessUTF16(b []byte) binary.ByteOrder {
+	if len(b)%2 != 0 {
+		return nil
+	}
+	var odd, even int
+	for i, c := range b {
+		if c != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	switch {
+	case even == 0 && odd > 0:
+		return binary.LittleEndian
+	case odd == 0 && even > 0:
+		return binary.BigEndian
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestDecodeText(t *testing.T) {
+	type test struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}
+
+	tests := []test{
+		{
+			name:  "UTF-8",
+			input: []byte("SELECT 'é';"),
+			want:  "SELECT 'é';",
+		},
+		{
+			name:  "UTF-8 BOM",
+			input: append([]byte{0xEF, 0xBB, 0xBF}, "SELECT 1;"...),
+			want:  "SELECT 1;",
+		},
+		{
+			name:  "UTF-16LE BOM",
+			input: []byte{0xFF, 0xFE, 'S', 0, 0xE9, 0, ';', 0},
+			want:  "Sé;",
+		},
+		{
+			name:  "UTF-16BE BOM",
+			input: []byte{0xFE, 0xFF, 0, 'S', 0, 0xE9, 0, ';'},
+			want:  "Sé;",
+		},
+		{
+			name:  "UTF-16LE without BOM",
+			input: []byte{'S', 0, 'Q', 0, 'L', 0},
+			want:  "SQL",
+		},
+		{
+			name:    "UTF-32LE BOM",
+			input:   []byte{0xFF, 0xFE, 0, 0, 'S', 0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "Latin-1",
+			input:   []byte{'S', 0xE9, ';'},
+			wantErr: true,
+		},
+		{
+			name:    "Binary",
+			input:   []byte{0, 0, 1, 0},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeText(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expect error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("Expect %q, got %q", tc.want, got)
+			}
+		})
+	}
+}